@@ -1,25 +1,37 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/stolostron/insights-client/pkg/clientconfig"
 	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/metrics"
 	"github.com/stolostron/insights-client/pkg/monitor"
 	"github.com/stolostron/insights-client/pkg/processor"
 	"github.com/stolostron/insights-client/pkg/retriever"
+	"github.com/stolostron/insights-client/pkg/server"
 	"github.com/stolostron/insights-client/pkg/types"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// How long to wait for the HTTP server and the pipeline to stop on SIGTERM
+const shutdownTimeout = 20 * time.Second
+
 func main() {
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 	err := flag.Lookup("logtostderr").Value.Set("true")
 	if err != nil {
@@ -33,43 +45,89 @@ func main() {
 		glog.Info("Built from git commit: ", commit)
 	}
 
-	config.SetupConfig()
+	if err := config.SetupConfig(); err != nil {
+		glog.Exitf("Invalid configuration:\n%v", err)
+	}
+
+	// Cancelled on SIGTERM, stops every goroutine started below
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	var pipeline sync.WaitGroup
 
 	dynamicClient := config.GetDynamicClient()
 	fetchClusterIDs := make(chan types.ManagedClusterInfo)
 	fetchPolicyReports := make(chan types.ProcessorData)
 
 	monitor := monitor.NewClusterMonitor()
-	go monitor.WatchClusters()
+	monitor.WatchClusters(ctx)
 
 	// Set up Retriever and cache the Insights data
-	ret := retriever.NewRetriever(config.Cfg.CCXServer, nil, config.Cfg.CCXToken)
-	//Wait for hub cluster id to make GET API call
-	hubID := "-1"
-	for hubID == "-1" {
-		var versionResource *unstructured.Unstructured
-		//If Local cluster is added and is not empty, get hub ID
-		if monitor.AddLocalCluster(versionResource) && monitor.GetLocalCluster() != "" {
-			hubID = monitor.GetLocalCluster()
-		}
-		glog.Info("Waiting for local-cluster Id.")
-		time.Sleep(2 * time.Second)
+	tokens, err := credentials.NewProvider(config.Cfg, config.GetKubeClient())
+	if err != nil {
+		glog.Exit(err)
 	}
+	// Trust the system CAs, the cluster trusted CA bundle, CACert and CA_FILES, e.g. the CA of an intercepting proxy
+	caBundle, err := retriever.NewCABundle(config.Cfg.CACert, retriever.ParseCAFiles(config.Cfg.CAFiles))
+	if err != nil {
+		glog.Exit(err)
+	}
+	ret := retriever.NewRetriever(config.Cfg.CCXServer, nil, tokens)
+	caBundle.OnChange(ret.SetRootCAs)
+	caBundle.Watch(ctx, config.GetKubeClient(), config.Cfg.TrustedCA)
+	ret.SetRootCAs(caBundle.Pool())
+	// Send the requests through the cluster-wide proxy and trust its CA
+	retriever.NewProxyWatcher(ret, caBundle).Watch(ctx, dynamicClient, config.GetKubeClient())
+	// Clusters of other organizations use the credentials mapped in the InsightsClientConfig
+	clusterCredentials := credentials.NewClusterCredentials(tokens, config.GetKubeClient(), config.Cfg.PodNamespace,
+		func(name string) (map[string]string, map[string]string) {
+			return monitor.GetClusterLabels(name), monitor.GetClusterAnnotations(name)
+		},
+	)
+	ret.Credentials = clusterCredentials
 
-	// Fetch the reports for each cluster & create the PolicyReport resources for each violation.
-	go ret.RetrieveReport(hubID, fetchClusterIDs, fetchPolicyReports, monitor.ClusterNeedsCCX, ret.DisconnectedEnv)
-
-	processor := processor.NewProcessor()
-	go processor.ProcessPolicyReports(fetchPolicyReports, dynamicClient)
+	// Apply the configuration changes made in the ConfigMap or before a SIGHUP to the running pipeline
+	config.OnChange(func(previous config.Config, current config.Config) {
+		if current.PollInterval != previous.PollInterval {
+			monitor.SetPollInterval(current.PollInterval)
+		}
+		if current.CCXServer != previous.CCXServer {
+			ret.SetReportURL(current.CCXServer)
+		}
+		if current.RequestsPerSecond() != previous.RequestsPerSecond() {
+			ret.SetRequestRate(current.RequestsPerSecond())
+		}
+	})
+	config.WatchReloads(ctx, config.GetKubeClient(), config.Cfg.PodNamespace)
 
-	refreshToken := config.Cfg.CCXToken != "" || ret.DisconnectedEnv
-	//start triggering reports for clusters
-	go ret.FetchClusters(monitor, fetchClusterIDs, refreshToken, hubID, dynamicClient)
+	// Apply the InsightsClientConfig resource
+	clientConfig := clientconfig.NewController()
+	ret.ClientConfig = clientConfig
+	clientConfig.Credentials = clusterCredentials
+	go clientConfig.Watch(ctx, dynamicClient, config.GetKubeClient().Discovery())
 
+	// Start serving before waiting for the hub ID so the probes can report progress.
 	router := mux.NewRouter()
+	server.AddHealthRoutes(router, server.NewHealthHandler(monitor, ret))
+	authorizer := server.NewKubeAuthorizer(config.GetKubeClient())
+	// The metrics have the violations of every cluster, only the callers allowed to get /metrics can read them
+	metrics.AddMetricsRoute(router, server.MetricsAuthMiddleware(authorizer))
+	api := server.NewAPI(ctx, monitor, ret.Store, fetchClusterIDs, authorizer)
+	api.SelectsCluster = func(name string) bool {
+		return clientConfig.SelectsCluster(monitor.GetClusterLabels(name))
+	}
+	api.CredentialsName = clusterCredentials.CredentialsName
+	server.AddAPIRoutes(router, api)
+
+	// Serve the certificate from ./sslcert and reload it when the secret is rotated
+	certLoader, err := server.NewCertLoader("./sslcert/tls.crt", "./sslcert/tls.key")
+	if err != nil {
+		log.Fatal(err, " Use ./setup.sh to generate certificates for local development.")
+	}
+	go certLoader.Watch(ctx)
 
 	// Configure TLS
 	cfg := &tls.Config{
+		GetCertificate:           certLoader.GetCertificate,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
@@ -81,13 +139,88 @@ func main() {
 		Addr:              config.Cfg.ServicePort,
 		Handler:           router,
 		TLSConfig:         cfg,
-		ReadHeaderTimeout: time.Duration(config.Cfg.HTTPTimeout) * time.Millisecond,
-		ReadTimeout:       time.Duration(config.Cfg.HTTPTimeout) * time.Millisecond,
-		WriteTimeout:      time.Duration(config.Cfg.HTTPTimeout) * time.Millisecond,
+		ReadHeaderTimeout: config.Cfg.HTTPTimeout,
+		ReadTimeout:       config.Cfg.HTTPTimeout,
+		WriteTimeout:      config.Cfg.HTTPTimeout,
 		TLSNextProto:      make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
 	}
 
 	glog.Info("insights-client listening on", config.Cfg.ServicePort)
-	log.Fatal(srv.ListenAndServeTLS("./sslcert/tls.crt", "./sslcert/tls.key"),
-		" Use ./setup.sh to generate certificates for local development.")
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServeTLS("", "")
+	}()
+
+	//Wait for hub cluster id to make GET API call
+	hubID := "-1"
+	for hubID == "-1" {
+		var versionResource *unstructured.Unstructured
+		//If Local cluster is added and is not empty, get hub ID
+		if monitor.AddLocalCluster(ctx, versionResource) && monitor.GetLocalCluster() != "" {
+			hubID = monitor.GetLocalCluster()
+		}
+		glog.Info("Waiting for local-cluster Id.")
+		select {
+		case <-ctx.Done():
+			shutdown(srv, &pipeline)
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	// Fetch the reports for each cluster & create the PolicyReport resources for each violation.
+	runPipeline(&pipeline, func() {
+		ret.RetrieveReport(ctx, hubID, fetchClusterIDs, fetchPolicyReports, monitor.NeedsCCX, ret.DisconnectedEnv)
+	})
+
+	processor := processor.NewProcessor()
+	processor.Store = ret.Store
+	processor.ClientConfig = clientConfig
+	runPipeline(&pipeline, func() {
+		processor.ProcessPolicyReports(ctx, fetchPolicyReports, dynamicClient)
+	})
+
+	//start triggering reports for clusters
+	runPipeline(&pipeline, func() {
+		ret.FetchClusters(ctx, monitor, fetchClusterIDs, fetchPolicyReports, hubID, dynamicClient)
+	})
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+		shutdown(srv, &pipeline)
+	}
+}
+
+// runPipeline starts a stage of the pipeline, shutdown waits for it to return
+func runPipeline(pipeline *sync.WaitGroup, stage func()) {
+	pipeline.Add(1)
+	go func() {
+		defer pipeline.Done()
+		stage()
+	}()
+}
+
+// shutdown stops the HTTP server and waits for the pipeline stages, so the PolicyReport
+// being written when SIGTERM is received is not left half-written
+func shutdown(srv *http.Server, pipeline *sync.WaitGroup) {
+	glog.Info("Shutting down insights-client")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		glog.Warningf("Error shutting down the HTTP server: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		pipeline.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		glog.Info("insights-client stopped")
+	case <-ctx.Done():
+		glog.Warning("Timed out waiting for the pipeline to stop")
+	}
 }
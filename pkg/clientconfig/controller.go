@@ -0,0 +1,423 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clientconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+// How often to check if the InsightsClientConfig CRD is installed
+const crdCheckInterval = time.Minute
+
+// Controller applies the InsightsClientConfig resource. The polling intervals are applied through the
+// config package, the other settings are read by the Processor and the Retriever.
+type Controller struct {
+	lock        sync.RWMutex
+	settings    settings
+	lastSpec    *InsightsClientConfigSpec // spec of the last reconcile, nil when the resource doesn't exist
+	client      dynamic.Interface
+	Credentials *credentials.ClusterCredentials // receives the credential mappings when set
+}
+
+// settings applied from the spec
+type settings struct {
+	pollInterval    time.Duration
+	requestInterval time.Duration
+	include         []string
+	exclude         []string
+	minTotalRisk    int
+	policyReports   bool
+	metrics         bool
+	selector        labels.Selector
+	credentials     []credentials.Mapping
+}
+
+func defaultSettings() settings {
+	return settings{
+		policyReports: true,
+		metrics:       true,
+		selector:      labels.Everything(),
+	}
+}
+
+// NewController returns a controller applying the default settings until Watch finds the resource
+func NewController() *Controller {
+	return &Controller{settings: defaultSettings()}
+}
+
+// Watch applies the InsightsClientConfig named ConfigName until the context is cancelled.
+// The informer is started once the CRD is installed.
+func (c *Controller) Watch(ctx context.Context, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface) {
+	c.lock.Lock()
+	c.client = dynamicClient
+	c.lock.Unlock()
+
+	for !isCRDInstalled(discoveryClient) {
+		glog.V(2).Infof("InsightsClientConfig CRD is not installed, checking again in %s", crdCheckInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(crdCheckInterval):
+		}
+	}
+
+	glog.Info("Begin InsightsClientConfig watch routine")
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, metav1.NamespaceAll,
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ConfigName).String()
+		},
+	)
+	informer := factory.ForResource(insightsClientConfigGvr).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.reconcile(ctx, obj)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			c.reconcile(ctx, next)
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.reset()
+		},
+	})
+	if err != nil {
+		glog.Error("Error adding eventHandler for InsightsClientConfig: ", err)
+	}
+	factory.Start(ctx.Done())
+}
+
+func isCRDInstalled(discoveryClient discovery.DiscoveryInterface) bool {
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(GroupVersion.String())
+	if err != nil {
+		return false
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == insightsClientConfigGvr.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcile validates and applies the spec, then writes the status conditions
+func (c *Controller) reconcile(ctx context.Context, obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	resource := InsightsClientConfig{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &resource); err != nil {
+		glog.Warningf("Error unstructuring InsightsClientConfig %s: %v", u.GetName(), err)
+		return
+	}
+
+	c.lock.RLock()
+	// Status updates trigger an update event with the same spec
+	unchanged := c.lastSpec != nil && reflect.DeepEqual(*c.lastSpec, resource.Spec)
+	c.lock.RUnlock()
+	if unchanged {
+		return
+	}
+
+	newSettings, errs := resource.Spec.settings()
+	if len(errs) == 0 {
+		err := config.SetResourceOverrides(config.Config{
+			PollInterval:    newSettings.pollInterval,
+			RequestInterval: newSettings.requestInterval,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		// Only an applied spec is skipped, a spec that failed is applied again on the next event
+		spec := resource.Spec
+		c.lock.Lock()
+		c.settings = newSettings
+		c.lastSpec = &spec
+		c.lock.Unlock()
+		c.setCredentials(newSettings.credentials)
+		glog.Infof("Applied InsightsClientConfig %s: %s", resource.GetName(), c.describe())
+	} else {
+		glog.Errorf("Invalid InsightsClientConfig %s, keeping the current configuration: %v",
+			resource.GetName(), errors.Join(errs...))
+	}
+	c.updateStatus(ctx, &resource, errs)
+}
+
+// reset restores the default settings when the resource is deleted
+func (c *Controller) reset() {
+	glog.Infof("InsightsClientConfig %s deleted, restoring the default configuration", ConfigName)
+	c.lock.Lock()
+	c.settings = defaultSettings()
+	c.lastSpec = nil
+	c.lock.Unlock()
+	c.setCredentials(nil)
+	if err := config.SetResourceOverrides(config.Config{}); err != nil {
+		glog.Errorf("Error restoring the configuration: %v", err)
+	}
+}
+
+func (c *Controller) setCredentials(mappings []credentials.Mapping) {
+	if c.Credentials != nil {
+		c.Credentials.SetMappings(mappings)
+	}
+}
+
+// updateStatus writes the Valid and Applied conditions if they changed
+func (c *Controller) updateStatus(ctx context.Context, resource *InsightsClientConfig, errs []error) {
+	status := InsightsClientConfigStatus{
+		ObservedGeneration: resource.GetGeneration(),
+		Conditions:         append([]metav1.Condition{}, resource.Status.Conditions...),
+	}
+	valid := metav1.Condition{
+		Type:               ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Valid",
+		Message:            "The configuration is valid",
+		ObservedGeneration: resource.GetGeneration(),
+	}
+	applied := metav1.Condition{
+		Type:               ConditionApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Applied",
+		Message:            "Applying " + c.describe(),
+		ObservedGeneration: resource.GetGeneration(),
+	}
+	if len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		valid.Status = metav1.ConditionFalse
+		valid.Reason = "ValidationFailed"
+		valid.Message = strings.Join(messages, "; ")
+		applied.Status = metav1.ConditionFalse
+		applied.Reason = "KeepingPreviousConfiguration"
+	}
+	meta.SetStatusCondition(&status.Conditions, valid)
+	meta.SetStatusCondition(&status.Conditions, applied)
+	if reflect.DeepEqual(status, resource.Status) {
+		return
+	}
+
+	resource.Status = status
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	if err != nil {
+		glog.Warningf("Error converting InsightsClientConfig to unstructured.Unstructured: %v", err)
+		return
+	}
+	c.lock.RLock()
+	client := c.client
+	c.lock.RUnlock()
+	_, err = client.Resource(insightsClientConfigGvr).UpdateStatus(
+		ctx,
+		&unstructured.Unstructured{Object: content},
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		glog.Warningf("Error updating the status of InsightsClientConfig %s: %v", resource.GetName(), err)
+	}
+}
+
+// describe returns the configuration being applied
+func (c *Controller) describe() string {
+	cfg := config.Get()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return fmt.Sprintf(
+		"pollInterval=%s requestInterval=%s rules.include=%v rules.exclude=%v minTotalRisk=%d "+
+			"sinks.policyReports=%t sinks.metrics=%t clusterSelector=%q credentials=%v",
+		cfg.PollInterval, cfg.RequestInterval, c.settings.include, c.settings.exclude, c.settings.minTotalRisk,
+		c.settings.policyReports, c.settings.metrics, c.settings.selector.String(), c.settings.credentialNames(),
+	)
+}
+
+// settings validates the spec and returns every error found
+func (s InsightsClientConfigSpec) settings() (settings, []error) {
+	result := defaultSettings()
+	var errs []error
+	var err error
+	if result.pollInterval, err = parseInterval("spec.pollInterval", s.PollInterval, time.Minute); err != nil {
+		errs = append(errs, err)
+	}
+	if result.requestInterval, err = parseInterval("spec.requestInterval", s.RequestInterval, time.Second); err != nil {
+		errs = append(errs, err)
+	}
+	if s.MinTotalRisk < 0 || s.MinTotalRisk > 4 {
+		errs = append(errs, fmt.Errorf("spec.minTotalRisk %d must be between 0 and 4", s.MinTotalRisk))
+	}
+	for _, rule := range append(append([]string{}, s.Rules.Include...), s.Rules.Exclude...) {
+		if strings.TrimSpace(rule) == "" {
+			errs = append(errs, errors.New("spec.rules must not contain empty rule IDs"))
+			break
+		}
+	}
+	if s.ClusterSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(s.ClusterSelector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid spec.clusterSelector: %v", err))
+		} else {
+			result.selector = selector
+		}
+	}
+	names := map[string]bool{}
+	for i, mapping := range s.Credentials {
+		field := fmt.Sprintf("spec.credentials[%d]", i)
+		if mapping.Name == "" || names[mapping.Name] {
+			errs = append(errs, fmt.Errorf("%s.name must be set and unique", field))
+		}
+		names[mapping.Name] = true
+		if mapping.SecretRef.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.secretRef.name must be set", field))
+		}
+		selector, err := mapping.selector()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", field, err))
+			continue
+		}
+		namespace := mapping.SecretRef.Namespace
+		if namespace == "" {
+			namespace = config.Get().PodNamespace
+		}
+		result.credentials = append(result.credentials, credentials.Mapping{
+			Name:     mapping.Name,
+			Selector: selector,
+			Secret:   ktypes.NamespacedName{Namespace: namespace, Name: mapping.SecretRef.Name},
+		})
+	}
+	result.include = s.Rules.Include
+	result.exclude = s.Rules.Exclude
+	result.minTotalRisk = s.MinTotalRisk
+	if s.Sinks.PolicyReports != nil {
+		result.policyReports = *s.Sinks.PolicyReports
+	}
+	if s.Sinks.Metrics != nil {
+		result.metrics = *s.Sinks.Metrics
+	}
+	return result, errs
+}
+
+// selector selects the clusters of the cluster set and matching the cluster selector
+func (m CredentialMapping) selector() (labels.Selector, error) {
+	if m.ClusterSet == "" && m.ClusterSelector == nil {
+		return nil, errors.New("clusterSet or clusterSelector must be set")
+	}
+	selector := labels.Everything()
+	if m.ClusterSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(m.ClusterSelector); err != nil {
+			return nil, fmt.Errorf("invalid clusterSelector: %v", err)
+		}
+	}
+	if m.ClusterSet != "" {
+		requirement, err := labels.NewRequirement(credentials.ClusterSetLabel, selection.Equals, []string{m.ClusterSet})
+		if err != nil {
+			return nil, fmt.Errorf("invalid clusterSet: %v", err)
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+func (s settings) credentialNames() []string {
+	names := make([]string, 0, len(s.credentials))
+	for _, mapping := range s.credentials {
+		names = append(names, mapping.Name)
+	}
+	return names
+}
+
+// parseInterval parses a duration string or an integer number of units, 0 when not set
+func parseInterval(name string, value *intstr.IntOrString, unit time.Duration) (time.Duration, error) {
+	if value == nil {
+		return 0, nil
+	}
+	interval, err := config.ParseDuration(value.String(), unit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected a duration such as 15m or an integer", name, value.String())
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("%s %s must not be negative", name, interval)
+	}
+	return interval, nil
+}
+
+// FilterResults drops the Insights rules not selected by the rule lists and the results below the total risk threshold
+func (c *Controller) FilterResults(results []v1beta1.PolicyReportResult) []v1beta1.PolicyReportResult {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var filtered []v1beta1.PolicyReportResult
+	for _, result := range results {
+		if result.Source == "insights" && !c.settings.keepRule(result.Policy) {
+			continue
+		}
+		if totalRisk, _ := strconv.Atoi(result.Properties["total_risk"]); totalRisk < c.settings.minTotalRisk {
+			continue
+		}
+		filtered = append(filtered, result)
+	}
+	return filtered
+}
+
+// keepRule matches the rule ID or its plugin name against the rule lists
+func (s settings) keepRule(ruleID string) bool {
+	plugin := strings.SplitN(ruleID, "|", 2)[0]
+	matches := func(rules []string) bool {
+		for _, rule := range rules {
+			if rule == ruleID || rule == plugin {
+				return true
+			}
+		}
+		return false
+	}
+	if len(s.include) > 0 && !matches(s.include) {
+		return false
+	}
+	return !matches(s.exclude)
+}
+
+// WritePolicyReports returns false if the PolicyReport sink is disabled
+func (c *Controller) WritePolicyReports() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.settings.policyReports
+}
+
+// PublishMetrics returns false if the violation metrics sink is disabled
+func (c *Controller) PublishMetrics() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.settings.metrics
+}
+
+// SelectsCluster returns true if reports are retrieved for a ManagedCluster with these labels
+func (c *Controller) SelectsCluster(clusterLabels map[string]string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.settings.selector.Matches(labels.Set(clusterLabels))
+}
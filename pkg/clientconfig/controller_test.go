@@ -0,0 +1,214 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clientconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfakeclient "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+func newResult(source string, policy string, totalRisk string) v1beta1.PolicyReportResult {
+	return v1beta1.PolicyReportResult{
+		Source:     source,
+		Policy:     policy,
+		Properties: map[string]string{"total_risk": totalRisk},
+	}
+}
+
+func Test_FilterResults(t *testing.T) {
+	c := NewController()
+	results := []v1beta1.PolicyReportResult{
+		newResult("insights", "ccx_rules_ocp.external.rules.nodes_kubelet_version_check|NODE_KUBELET_VERSION", "2"),
+		newResult("insights", "ccx_rules_ocp.external.rules.image_registry_pv_not_bound|IMAGE_REGISTRY_PV_NOT_BOUND", "3"),
+		newResult("insights", "ccx_rules_ocp.external.rules.samples_op_failed_image_import_check|SAMPLES_FAILED_IMAGE_IMPORT_ERR", "1"),
+		newResult("grc", "policy-namespace", "1"),
+	}
+	assert.Equal(t, 4, len(c.FilterResults(results)), "Test default settings keep every result")
+
+	c.settings, _ = InsightsClientConfigSpec{
+		Rules: RuleFilter{
+			Exclude: []string{"ccx_rules_ocp.external.rules.image_registry_pv_not_bound"},
+		},
+		MinTotalRisk: 2,
+	}.settings()
+	filtered := c.FilterResults(results)
+	assert.Equal(t, []v1beta1.PolicyReportResult{results[0]}, filtered, "Test excluded plugin and low risk results dropped")
+
+	c.settings, _ = InsightsClientConfigSpec{
+		Rules: RuleFilter{
+			Include: []string{"ccx_rules_ocp.external.rules.samples_op_failed_image_import_check|SAMPLES_FAILED_IMAGE_IMPORT_ERR"},
+		},
+	}.settings()
+	filtered = c.FilterResults(results)
+	assert.Equal(t, []v1beta1.PolicyReportResult{results[2], results[3]}, filtered,
+		"Test only the included rule is kept, governance results are not filtered by rule")
+}
+
+func Test_SelectsCluster(t *testing.T) {
+	c := NewController()
+	assert.True(t, c.SelectsCluster(nil), "Test every cluster selected by default")
+
+	c.settings, _ = InsightsClientConfigSpec{
+		ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"environment": "prod"}},
+	}.settings()
+	assert.True(t, c.SelectsCluster(map[string]string{"environment": "prod", "vendor": "OpenShift"}), "Test matching cluster")
+	assert.False(t, c.SelectsCluster(map[string]string{"environment": "dev"}), "Test not matching cluster")
+}
+
+func Test_settings_Invalid(t *testing.T) {
+	_, errs := InsightsClientConfigSpec{
+		PollInterval:    &intstr.IntOrString{Type: intstr.Int, IntVal: -1},
+		RequestInterval: &intstr.IntOrString{Type: intstr.String, StrVal: "2 seconds"},
+		MinTotalRisk:    5,
+		Rules:           RuleFilter{Include: []string{""}},
+		ClusterSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: "Unknown"},
+		}},
+	}.settings()
+	assert.Equal(t, 5, len(errs), "Test every validation error returned")
+}
+
+func conditionStatus(t *testing.T, client *dynamicfakeclient.FakeDynamicClient, conditionType string) metav1.ConditionStatus {
+	u, err := client.Resource(insightsClientConfigGvr).Get(context.TODO(), ConfigName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	resource := InsightsClientConfig{}
+	assert.Nil(t, runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &resource))
+	condition := meta.FindStatusCondition(resource.Status.Conditions, conditionType)
+	if condition == nil {
+		return metav1.ConditionUnknown
+	}
+	return condition.Status
+}
+
+func Test_Watch(t *testing.T) {
+	assert.Nil(t, config.SetupConfig())
+	defer func() { _ = config.SetResourceOverrides(config.Config{}) }()
+
+	resource := &InsightsClientConfig{
+		TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "InsightsClientConfig"},
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigName, Generation: 1},
+		Spec: InsightsClientConfigSpec{
+			PollInterval:    &intstr.IntOrString{Type: intstr.Int, IntVal: 7},
+			RequestInterval: &intstr.IntOrString{Type: intstr.String, StrVal: "500ms"},
+			Sinks:           Sinks{Metrics: new(bool)},
+		},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	assert.Nil(t, err)
+	dynamicClient := dynamicfakeclient.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{insightsClientConfigGvr: "InsightsClientConfigList"},
+		&unstructured.Unstructured{Object: content},
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: GroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "insightsclientconfigs"}},
+	}}
+
+	c := NewController()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Watch(ctx, dynamicClient, discoveryClient)
+
+	assert.Eventually(t, func() bool {
+		return conditionStatus(t, dynamicClient, ConditionApplied) == metav1.ConditionTrue
+	}, 5*time.Second, 10*time.Millisecond, "Test Applied condition written")
+	assert.Equal(t, metav1.ConditionTrue, conditionStatus(t, dynamicClient, ConditionValid), "Test Valid condition written")
+	assert.Equal(t, 7*time.Minute, config.Get().PollInterval, "Test poll interval in minutes applied")
+	assert.Equal(t, 500*time.Millisecond, config.Get().RequestInterval, "Test request interval duration applied")
+	assert.False(t, c.PublishMetrics(), "Test metrics sink disabled")
+	assert.True(t, c.WritePolicyReports(), "Test PolicyReport sink enabled by default")
+
+	// An invalid spec is reported and the previous configuration is kept
+	u, err := dynamicClient.Resource(insightsClientConfigGvr).Get(ctx, ConfigName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, unstructured.SetNestedField(u.Object, int64(-5), "spec", "pollInterval"))
+	_, err = dynamicClient.Resource(insightsClientConfigGvr).Update(ctx, u, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return conditionStatus(t, dynamicClient, ConditionValid) == metav1.ConditionFalse
+	}, 5*time.Second, 10*time.Millisecond, "Test Valid condition set to false")
+	assert.Equal(t, metav1.ConditionFalse, conditionStatus(t, dynamicClient, ConditionApplied), "Test Applied condition set to false")
+	assert.Equal(t, 7*time.Minute, config.Get().PollInterval, "Test previous poll interval kept")
+
+	// Deleting the resource restores the defaults
+	assert.Nil(t, dynamicClient.Resource(insightsClientConfigGvr).Delete(ctx, ConfigName, metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		return config.Get().PollInterval == config.DEFAULT_POLL_INTERVAL && c.PublishMetrics()
+	}, 5*time.Second, 10*time.Millisecond, "Test defaults restored")
+}
+
+func Test_settings_Credentials(t *testing.T) {
+	assert.Nil(t, config.SetupConfig())
+	result, errs := InsightsClientConfigSpec{
+		Credentials: []CredentialMapping{
+			{Name: "org-a", ClusterSet: "org-a-clusters", SecretRef: SecretReference{Name: "org-a"}},
+			{
+				Name:            "org-b",
+				ClusterSet:      "shared",
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"org": "b"}},
+				SecretRef:       SecretReference{Namespace: "credentials", Name: "org-b"},
+			},
+		},
+	}.settings()
+	assert.Empty(t, errs)
+	assert.Equal(t, 2, len(result.credentials))
+	assert.Equal(t, config.Get().PodNamespace, result.credentials[0].Secret.Namespace, "Test secret namespace defaults to POD_NAMESPACE")
+	assert.True(t, result.credentials[0].Selector.Matches(labels.Set{credentials.ClusterSetLabel: "org-a-clusters"}),
+		"Test cluster set selected")
+	assert.False(t, result.credentials[1].Selector.Matches(labels.Set{"org": "b"}),
+		"Test both the cluster set and the selector must match")
+	assert.True(t, result.credentials[1].Selector.Matches(labels.Set{credentials.ClusterSetLabel: "shared", "org": "b"}))
+
+	_, errs = InsightsClientConfigSpec{
+		Credentials: []CredentialMapping{
+			{Name: "org-a", ClusterSet: "org-a", SecretRef: SecretReference{Name: "org-a"}},
+			{Name: "org-a", SecretRef: SecretReference{}},
+		},
+	}.settings()
+	assert.Equal(t, 3, len(errs), "Test duplicate name, missing secret and missing cluster selection")
+}
+
+// A spec that failed to apply is not recorded, so the next event with the same spec applies it again
+func Test_reconcile_RetriesFailedSpec(t *testing.T) {
+	assert.Nil(t, config.SetupConfig())
+	defer func() { _ = config.SetResourceOverrides(config.Config{}) }()
+	resource := &InsightsClientConfig{
+		TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "InsightsClientConfig"},
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigName},
+		Spec:       InsightsClientConfigSpec{PollInterval: &intstr.IntOrString{Type: intstr.Int, IntVal: -1}},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	assert.Nil(t, err)
+	c := NewController()
+	c.client = dynamicfakeclient.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{insightsClientConfigGvr: "InsightsClientConfigList"},
+		&unstructured.Unstructured{Object: content},
+	)
+
+	c.reconcile(context.TODO(), &unstructured.Unstructured{Object: content})
+	assert.Nil(t, c.lastSpec, "Test invalid spec not recorded")
+
+	resource.Spec.PollInterval = &intstr.IntOrString{Type: intstr.Int, IntVal: 9}
+	content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	assert.Nil(t, err)
+	c.reconcile(context.TODO(), &unstructured.Unstructured{Object: content})
+	assert.NotNil(t, c.lastSpec, "Test applied spec recorded")
+	assert.Equal(t, 9*time.Minute, config.Get().PollInterval, "Test poll interval applied")
+}
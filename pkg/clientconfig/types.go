@@ -0,0 +1,88 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clientconfig
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ConfigName - name of the InsightsClientConfig applied by the client, the others are ignored
+const ConfigName = "insights-client"
+
+// Condition types set in the InsightsClientConfig status
+const (
+	ConditionValid   = "Valid"
+	ConditionApplied = "Applied"
+)
+
+// GroupVersion of the InsightsClientConfig resource
+var GroupVersion = schema.GroupVersion{Group: "insights.open-cluster-management.io", Version: "v1alpha1"}
+
+var insightsClientConfigGvr = GroupVersion.WithResource("insightsclientconfigs")
+
+// InsightsClientConfig is the cluster scoped resource configuring the insights-client
+type InsightsClientConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InsightsClientConfigSpec   `json:"spec,omitempty"`
+	Status InsightsClientConfigStatus `json:"status,omitempty"`
+}
+
+// InsightsClientConfigSpec - the zero value of each field keeps the current behavior
+type InsightsClientConfigSpec struct {
+	// Time between two reports of the same cluster, overrides POLL_INTERVAL. A duration such as "1h" or minutes.
+	PollInterval *intstr.IntOrString `json:"pollInterval,omitempty"`
+	// Time between two consecutive CCX requests, overrides REQUEST_INTERVAL and REQUEST_RATE. A duration such as "500ms" or seconds.
+	RequestInterval *intstr.IntOrString `json:"requestInterval,omitempty"`
+	// Insights rules written to the PolicyReports
+	Rules RuleFilter `json:"rules,omitempty"`
+	// Results with a lower total_risk are not written, 0 keeps every result
+	MinTotalRisk int `json:"minTotalRisk,omitempty"`
+	// Where the results are written
+	Sinks Sinks `json:"sinks,omitempty"`
+	// Only the ManagedClusters matching the selector get reports, every cluster when not set
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+	// Credentials of the clusters registered in other organizations, the first matching entry is used
+	Credentials []CredentialMapping `json:"credentials,omitempty"`
+}
+
+// CredentialMapping - the clusters of the cluster set or matching the selector use the secret credentials
+type CredentialMapping struct {
+	// Name used in the logs and the errors
+	Name string `json:"name"`
+	// ManagedClusterSet of the clusters
+	ClusterSet string `json:"clusterSet,omitempty"`
+	// ManagedCluster labels of the clusters
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+	// Secret with a token key, or client_id and client_secret keys
+	SecretRef SecretReference `json:"secretRef"`
+}
+
+// SecretReference - the namespace defaults to POD_NAMESPACE
+type SecretReference struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// RuleFilter selects Insights rules by rule ID (plugin|ERROR_KEY) or plugin name
+type RuleFilter struct {
+	// Only these rules are kept when not empty
+	Include []string `json:"include,omitempty"`
+	// These rules are dropped
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Sinks - each sink is enabled when not set
+type Sinks struct {
+	PolicyReports *bool `json:"policyReports,omitempty"`
+	Metrics       *bool `json:"metrics,omitempty"`
+}
+
+// InsightsClientConfigStatus ...
+type InsightsClientConfigStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
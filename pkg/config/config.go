@@ -4,57 +4,382 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	"sigs.k8s.io/yaml"
 )
 
 const (
 	DEFAULT_SERVICE_PORT     = ":3030"
-	DEFAULT_HTTP_TIMEOUT     = 180000                                  // 3 minutes HTTP Timeout
+	DEFAULT_HTTP_TIMEOUT     = 3 * time.Minute // 3 minutes HTTP Timeout
 	DEFAULT_CCX_SERVER       = "https://console.redhat.com/api/insights-results-aggregator/v2"
-	DEFAULT_POLL_INTERVAL    = 30                                      // 30mins default polling interval cloud.redhat.com
-	DEFAULT_REQUEST_INTERVAL = 1                                       // 1 second Interval between 2 consecutive requests
-	DEFAULT_POD_NAMESPACE    = "kube-system"                           // Namespace of insights-client pod
+	DEFAULT_POLL_INTERVAL    = 30 * time.Minute // 30mins default polling interval cloud.redhat.com
+	DEFAULT_REQUEST_RATE     = 1.0              // CCX requests per second
+	DEFAULT_WORKERS          = 1                // Clusters retrieved concurrently
+	DEFAULT_MAX_ATTEMPTS     = 4                // Attempts of a CCX request, the first one and 3 retries
+	DEFAULT_MAX_BACKOFF      = time.Minute      // Longest wait before retrying a CCX request
+	DEFAULT_BREAKER_FAILURES = 5                // Consecutive failed CCX requests opening the circuit breaker
+	DEFAULT_BREAKER_COOLDOWN = 5 * time.Minute  // Time the circuit breaker stays open before probing CCX
+	DEFAULT_REPORT_EXPIRY    = 24 * time.Hour   // Age of the last report kept when fetching a new one fails
+	DEFAULT_POD_NAMESPACE    = "kube-system"    // Namespace of insights-client pod
+	DEFAULT_CCX_TOKEN_URL    = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+	DEFAULT_CCX_OAUTH_SCOPES = "api.console"
+	DEFAULT_TRUSTED_CA       = "openshift-config-managed/trusted-ca-bundle" // ConfigMap with the cluster trusted CA bundle
 )
 
 // Config - Define a config type to hold our config properties.
+// The json tags are the keys of the --config YAML file, each env tag also defines a flag,
+// e.g. POLL_INTERVAL can be set with --poll-interval.
+// Durations are Go duration strings such as "15m", a plain integer is read in the unit tag of the setting.
+// A RequestInterval of 0 means not set, RequestRate is used instead.
 type Config struct {
-	ServicePort     string `env:"SERVICE_PORT"`
-	CCXServer       string `env:"CCX_SERVER"`
-	HTTPTimeout     int    `env:"HTTP_TIMEOUT"`     // timeout when the http server should drop connections
-	KubeConfig      string `env:"KUBECONFIG"`       // Local kubeconfig path
-	CCXToken        string `env:"CCX_TOKEN"`        // Token to access CCX server , when pull-secret cannot be used
-	PollInterval    int    `env:"POLL_INTERVAL"`    // Polling interval to reports from cloud.redhat.com
-	RequestInterval int    `env:"REQUEST_INTERVAL"` // Interval between 2 consequent requests
-	CACert          string `env:"CACert"`           // base64 encoded caCert used for dev & test
-	PodNamespace    string `env:"POD_NAMESPACE"`    // Namespace of insights-client pod
-}
-
-// Cfg service configuration
+	ServicePort     string        `env:"SERVICE_PORT" json:"servicePort"`
+	CCXServer       string        `env:"CCX_SERVER" json:"ccxServer"`
+	HTTPTimeout     time.Duration `env:"HTTP_TIMEOUT" json:"httpTimeout" unit:"ms"`                       // timeout when the http server should drop connections
+	KubeConfig      string        `env:"KUBECONFIG" json:"kubeConfig"`                                    // Local kubeconfig path
+	CCXToken        string        `env:"CCX_TOKEN" json:"ccxToken"`                                       // Token to access CCX server , when pull-secret cannot be used
+	CCXTokenFile    string        `env:"CCX_TOKEN_FILE" json:"ccxTokenFile"`                              // File holding CCX_TOKEN, re-read when it changes
+	CCXTokenExec    string        `env:"CCX_TOKEN_EXEC" json:"ccxTokenExec"`                              // Command printing CCX_TOKEN
+	CCXOAuthSecret  string        `env:"CCX_OAUTH_SECRET" json:"ccxOAuthSecret"`                          // Secret in POD_NAMESPACE holding the OAuth2 client_id and client_secret
+	CCXTokenURL     string        `env:"CCX_TOKEN_URL" json:"ccxTokenURL"`                                // OAuth2 token endpoint used with CCX_OAUTH_SECRET
+	CCXOAuthScopes  string        `env:"CCX_OAUTH_SCOPES" json:"ccxOAuthScopes"`                          // Space separated OAuth2 scopes
+	PollInterval    time.Duration `env:"POLL_INTERVAL" json:"pollInterval" unit:"m"`                      // Polling interval to reports from cloud.redhat.com
+	RequestInterval time.Duration `env:"REQUEST_INTERVAL" json:"requestInterval" unit:"s"`                // Interval between 2 consequent requests, overrides RequestRate
+	RequestRate     float64       `env:"REQUEST_RATE" json:"requestRate"`                                 // CCX requests per second shared by the workers
+	Workers         int           `env:"RETRIEVAL_WORKERS" json:"retrievalWorkers"`                       // Clusters retrieved concurrently
+	MaxAttempts     int           `env:"CCX_MAX_ATTEMPTS" json:"ccxMaxAttempts"`                          // Attempts of a CCX request failing with 429, 5xx or a network error
+	MaxBackoff      time.Duration `env:"CCX_MAX_BACKOFF" json:"ccxMaxBackoff" unit:"s"`                   // Longest wait between 2 attempts
+	BreakerFailures int           `env:"CIRCUIT_BREAKER_FAILURES" json:"circuitBreakerFailures"`          // Consecutive failed CCX requests opening the circuit breaker
+	BreakerCooldown time.Duration `env:"CIRCUIT_BREAKER_COOLDOWN" json:"circuitBreakerCooldown" unit:"s"` // Time before probing CCX again
+	ReportExpiry    time.Duration `env:"REPORT_EXPIRY" json:"reportExpiry" unit:"h"`                      // Age after which the results of a report that can't be refreshed are dropped
+	CACert          string        `env:"CACert" json:"caCert"`                                            // base64 encoded caCert used for dev & test
+	TrustedCA       string        `env:"TRUSTED_CA_CONFIGMAP" json:"trustedCAConfigMap"`                  // namespace/name of the ConfigMap with the ca-bundle.crt key
+	CAFiles         string        `env:"CA_FILES" json:"caFiles"`                                         // Comma separated PEM files of extra CAs
+	PodNamespace    string        `env:"POD_NAMESPACE" json:"podNamespace"`                               // Namespace of insights-client pod
+}
+
+// Units of the plain integers accepted by the unit tag
+var units = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+var unitNames = map[string]string{
+	"ms": "milliseconds",
+	"s":  "seconds",
+	"m":  "minutes",
+	"h":  "hours",
+}
+
+// ParseDuration parses a Go duration string such as "15m" or "500ms", a plain integer is a number of units
+// for backward compatibility, e.g. "30" is 30 minutes with the time.Minute unit
+func ParseDuration(value string, unit time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// parseSetting parses a duration setting, the error names the setting and its integer unit
+func parseSetting(name string, unitTag string, value string) (time.Duration, error) {
+	parsed, err := ParseDuration(value, units[unitTag])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected a duration such as 15m or 500ms, or a number of %s",
+			name, value, unitNames[unitTag])
+	}
+	return parsed, nil
+}
+
+// UnmarshalJSON reads the --config file and ConfigMap keys, yaml.UnmarshalStrict converts the YAML to JSON.
+// Unknown keys are rejected and durations can be strings or integers.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var errs []error
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := field.Tag.Get("json")
+		message, ok := raw[key]
+		if !ok {
+			continue
+		}
+		delete(raw, key)
+		if string(message) == "null" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(message, &text); err != nil {
+			// numbers are kept as written
+			text = string(message)
+		}
+		if err := setField(value.Field(i), field, key, text); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for key := range raw {
+		errs = append(errs, fmt.Errorf("unknown field %q", key))
+	}
+	return errors.Join(errs...)
+}
+
+// setField sets a setting from its text value, name is used in the error
+func setField(field reflect.Value, structField reflect.StructField, name string, value string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		parsed, err := parseSetting(name, structField.Tag.Get("unit"), value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+	case int:
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: expected an integer", name, value)
+		}
+		field.SetInt(int64(parsed))
+	case float64:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: expected a number", name, value)
+		}
+		field.SetFloat(parsed)
+	default:
+		field.SetString(value)
+	}
+	return nil
+}
+
+// Cfg service configuration, use Get in code running while the configuration can be reloaded
 var Cfg = Config{}
 
+var cfgLock sync.RWMutex
+
+// Get returns a copy of the current configuration
+func Get() Config {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+	return Cfg
+}
+
 var message = "Using %s from environment: %s"
 
-func SetupConfig() {
-	// If environment variables are set, use those values constants
-	// Simply put, the order of preference is env -> default constants (from left to right)
-	setDefault(&Cfg.ServicePort, "SERVICE_PORT", DEFAULT_SERVICE_PORT)
-	setDefault(&Cfg.CCXServer, "CCX_SERVER", DEFAULT_CCX_SERVER)
-	setDefault(&Cfg.CCXToken, "CCX_TOKEN", "")
-	setDefault(&Cfg.CACert, "CACert", "")
-	setDefault(&Cfg.PodNamespace, "POD_NAMESPACE", DEFAULT_POD_NAMESPACE)
-	setDefaultInt(&Cfg.HTTPTimeout, "HTTP_TIMEOUT", DEFAULT_HTTP_TIMEOUT)
-	setDefaultInt(&Cfg.PollInterval, "POLL_INTERVAL", DEFAULT_POLL_INTERVAL)
-	setDefaultInt(&Cfg.RequestInterval, "REQUEST_INTERVAL", DEFAULT_REQUEST_INTERVAL)
+// Set by RegisterFlags
+var (
+	configFile string
+	flags      *flag.FlagSet
+)
+
+// RegisterFlags registers --config and a flag for each setting, must be called before the flags are parsed
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFile, "config", "", "Path of a YAML configuration file")
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		env := configType.Field(i).Tag.Get("env")
+		fs.String(flagName(env), "", "Overrides the "+env+" environment variable")
+	}
+	flags = fs
+}
+
+// flagName returns the flag of a setting, e.g. poll-interval for POLL_INTERVAL
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// SetupConfig loads the configuration, the order of preference is flags -> InsightsClientConfig resource
+// (see SetResourceOverrides) -> ConfigMap (see WatchReloads) -> env -> file -> default constants.
+// Every invalid setting is reported in the returned error, Cfg is only updated when the configuration is valid.
+func SetupConfig() error {
+	_, err := load()
+	return err
+}
+
+// load builds and validates the configuration, returns the previous configuration if it was replaced
+func load() (Config, error) {
+	cfg := Config{}
+	var errs []error
+	if configFile != "" {
+		if err := loadFile(&cfg, configFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// If environment variables are set, use those values, otherwise keep the value from the file or the default
+	setDefault(&cfg.ServicePort, "SERVICE_PORT", DEFAULT_SERVICE_PORT)
+	setDefault(&cfg.CCXServer, "CCX_SERVER", DEFAULT_CCX_SERVER)
+	setDefault(&cfg.CCXToken, "CCX_TOKEN", "")
+	setDefault(&cfg.CCXTokenFile, "CCX_TOKEN_FILE", "")
+	setDefault(&cfg.CCXTokenExec, "CCX_TOKEN_EXEC", "")
+	setDefault(&cfg.CCXOAuthSecret, "CCX_OAUTH_SECRET", "")
+	setDefault(&cfg.CCXTokenURL, "CCX_TOKEN_URL", DEFAULT_CCX_TOKEN_URL)
+	setDefault(&cfg.CCXOAuthScopes, "CCX_OAUTH_SCOPES", DEFAULT_CCX_OAUTH_SCOPES)
+	setDefault(&cfg.CACert, "CACert", "")
+	setDefault(&cfg.TrustedCA, "TRUSTED_CA_CONFIGMAP", DEFAULT_TRUSTED_CA)
+	setDefault(&cfg.CAFiles, "CA_FILES", "")
+	setDefault(&cfg.PodNamespace, "POD_NAMESPACE", DEFAULT_POD_NAMESPACE)
+	errs = append(errs,
+		setDefaultDuration(&cfg.HTTPTimeout, "HTTP_TIMEOUT", "ms", DEFAULT_HTTP_TIMEOUT),
+		setDefaultDuration(&cfg.PollInterval, "POLL_INTERVAL", "m", DEFAULT_POLL_INTERVAL),
+		setDefaultDuration(&cfg.RequestInterval, "REQUEST_INTERVAL", "s", 0),
+		setDefaultFloat(&cfg.RequestRate, "REQUEST_RATE", DEFAULT_REQUEST_RATE),
+		setDefaultInt(&cfg.Workers, "RETRIEVAL_WORKERS", DEFAULT_WORKERS),
+		setDefaultInt(&cfg.MaxAttempts, "CCX_MAX_ATTEMPTS", DEFAULT_MAX_ATTEMPTS),
+		setDefaultDuration(&cfg.MaxBackoff, "CCX_MAX_BACKOFF", "s", DEFAULT_MAX_BACKOFF),
+		setDefaultInt(&cfg.BreakerFailures, "CIRCUIT_BREAKER_FAILURES", DEFAULT_BREAKER_FAILURES),
+		setDefaultDuration(&cfg.BreakerCooldown, "CIRCUIT_BREAKER_COOLDOWN", "s", DEFAULT_BREAKER_COOLDOWN),
+		setDefaultDuration(&cfg.ReportExpiry, "REPORT_EXPIRY", "h", DEFAULT_REPORT_EXPIRY),
+	)
 	defaultKubePath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
 	if _, err := os.Stat(defaultKubePath); os.IsNotExist(err) {
 		// set default to empty string if path does not resolve
 		defaultKubePath = ""
 	}
-	setDefault(&Cfg.KubeConfig, "KUBECONFIG", defaultKubePath)
+	setDefault(&cfg.KubeConfig, "KUBECONFIG", defaultKubePath)
+
+	if err := applyConfigMap(&cfg); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, applyFlags(&cfg)...)
+	errs = append(errs, cfg.validate()...)
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+	previous := Cfg
+	Cfg = cfg
+	return previous, nil
+}
+
+// loadFile reads the YAML file into the config, unknown keys are rejected
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return fmt.Errorf("could not parse config file %s: %v", path, err)
+	}
+	glog.Infof("Loaded configuration from %s", path)
+	return nil
+}
+
+// applyFlags overrides the settings given on the command line
+func applyFlags(cfg *Config) []error {
+	if flags == nil {
+		return nil
+	}
+	var errs []error
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := flagName(field.Tag.Get("env"))
+		f := flags.Lookup(name)
+		if f == nil || !isFlagSet(name) {
+			continue
+		}
+		glog.V(2).Infof("Using %s from flag: %s", name, f.Value.String())
+		if err := setField(value.Field(i), field, "--"+name, f.Value.String()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// validate returns an error for each invalid setting
+func (c Config) validate() []error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.ServicePort); err != nil {
+		errs = append(errs, fmt.Errorf("invalid SERVICE_PORT %q: %v", c.ServicePort, err))
+	}
+	if u, err := url.Parse(c.CCXServer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid CCX_SERVER %q: expected an http(s) URL", c.CCXServer))
+	}
+	if c.HTTPTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid HTTP_TIMEOUT %s: must be greater than 0", c.HTTPTimeout))
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid POLL_INTERVAL %s: must be greater than 0", c.PollInterval))
+	}
+	if c.RequestInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid REQUEST_INTERVAL %s: must not be negative", c.RequestInterval))
+	}
+	if c.RequestRate <= 0 {
+		errs = append(errs, fmt.Errorf("invalid REQUEST_RATE %v: must be greater than 0", c.RequestRate))
+	}
+	if c.Workers < 1 {
+		errs = append(errs, fmt.Errorf("invalid RETRIEVAL_WORKERS %d: must be at least 1", c.Workers))
+	}
+	if c.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("invalid CCX_MAX_ATTEMPTS %d: must be at least 1", c.MaxAttempts))
+	}
+	if c.MaxBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("invalid CCX_MAX_BACKOFF %s: must be greater than 0", c.MaxBackoff))
+	}
+	if c.BreakerFailures < 1 {
+		errs = append(errs, fmt.Errorf("invalid CIRCUIT_BREAKER_FAILURES %d: must be at least 1", c.BreakerFailures))
+	}
+	if c.BreakerCooldown <= 0 {
+		errs = append(errs, fmt.Errorf("invalid CIRCUIT_BREAKER_COOLDOWN %s: must be greater than 0", c.BreakerCooldown))
+	}
+	if c.ReportExpiry <= 0 {
+		errs = append(errs, fmt.Errorf("invalid REPORT_EXPIRY %s: must be greater than 0", c.ReportExpiry))
+	}
+	tokenSources := 0
+	for _, source := range []string{c.CCXToken, c.CCXTokenFile, c.CCXTokenExec, c.CCXOAuthSecret} {
+		if source != "" {
+			tokenSources++
+		}
+	}
+	if tokenSources > 1 {
+		errs = append(errs, errors.New("only one of CCX_TOKEN, CCX_TOKEN_FILE, CCX_TOKEN_EXEC and CCX_OAUTH_SECRET can be set"))
+	}
+	if u, err := url.Parse(c.CCXTokenURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid CCX_TOKEN_URL %q: expected an http(s) URL", c.CCXTokenURL))
+	}
+	if namespace, name, found := strings.Cut(c.TrustedCA, "/"); !found || namespace == "" || name == "" {
+		errs = append(errs, fmt.Errorf("invalid TRUSTED_CA_CONFIGMAP %q: expected namespace/name", c.TrustedCA))
+	}
+	if c.PodNamespace == "" {
+		errs = append(errs, errors.New("POD_NAMESPACE must not be empty"))
+	}
+	return errs
+}
+
+// RequestsPerSecond returns the CCX request budget, one request per RequestInterval when it is set
+func (c Config) RequestsPerSecond() float64 {
+	if c.RequestInterval > 0 {
+		return float64(time.Second) / float64(c.RequestInterval)
+	}
+	return c.RequestRate
 }
 
 func setDefault(field *string, env, defaultVal string) {
@@ -67,18 +392,62 @@ func setDefault(field *string, env, defaultVal string) {
 	}
 }
 
-func setDefaultInt(field *int, env string, defaultVal int) {
+// setDefaultDuration returns an error if the environment variable is not a duration, the unit tag
+// applies to plain integers
+func setDefaultDuration(field *time.Duration, env string, unitTag string, defaultVal time.Duration) error {
+	var err error
 	if val := os.Getenv(env); val != "" {
 		glog.Infof(message, env, val)
-		var err error
-		*field, err = strconv.Atoi(val)
-		if err != nil {
-			glog.Error("Error parsing env [", env, "].  Expected an integer.  Original error: ", err)
+		parsed, parseErr := parseSetting(env, unitTag, val)
+		if parseErr == nil {
+			*field = parsed
+			return nil
+		}
+		err = parseErr
+	}
+	if *field == 0 && defaultVal != 0 {
+		glog.V(2).Infof("No %s from file or environment, using default value: %s", env, defaultVal)
+		*field = defaultVal
+	}
+	return err
+}
+
+// setDefaultInt returns an error if the environment variable is not an integer
+func setDefaultInt(field *int, env string, defaultVal int) error {
+	var err error
+	if val := os.Getenv(env); val != "" {
+		glog.Infof(message, env, val)
+		parsed, parseErr := strconv.Atoi(strings.TrimSpace(val))
+		if parseErr == nil {
+			*field = parsed
+			return nil
 		}
-	} else if *field == 0 && defaultVal != 0 {
+		err = fmt.Errorf("invalid %s %q: expected an integer", env, val)
+	}
+	if *field == 0 && defaultVal != 0 {
 		glog.V(2).Infof("No %s from file or environment, using default value: %d", env, defaultVal)
 		*field = defaultVal
 	}
+	return err
+}
+
+// setDefaultFloat returns an error if the environment variable is not a number
+func setDefaultFloat(field *float64, env string, defaultVal float64) error {
+	var err error
+	if val := os.Getenv(env); val != "" {
+		glog.Infof(message, env, val)
+		parsed, parseErr := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if parseErr == nil {
+			*field = parsed
+			return nil
+		}
+		err = fmt.Errorf("invalid %s %q: expected a number", env, val)
+	}
+	if *field == 0 && defaultVal != 0 {
+		glog.V(2).Infof("No %s from file or environment, using default value: %v", env, defaultVal)
+		*field = defaultVal
+	}
+	return err
 }
 
 func setDefaultBool(field *bool, env string, defaultVal bool) {
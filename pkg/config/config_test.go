@@ -4,8 +4,12 @@
 package config
 
 import (
+	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Should use default value when environment variable does not exist.
@@ -74,3 +78,232 @@ func Test_SetDefaultBool_02(t *testing.T) {
 		t.Errorf("Failed testing setDefaultBool()  Expected: %t  Got: %t", true, property)
 	}
 }
+
+func setupTestFlags(t *testing.T, file string, args ...string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	configFile = file
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		configFile = ""
+		flags = nil
+		_ = SetupConfig()
+	})
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Should prefer flags, then env, then file, then defaults.
+func Test_SetupConfig_Precedence(t *testing.T) {
+
+	path := writeConfigFile(t, "pollInterval: 10\nrequestInterval: 5\nhttpTimeout: 90s\nccxServer: https://file.example.com\n")
+	t.Setenv("POLL_INTERVAL", "20")
+	t.Setenv("REQUEST_INTERVAL", "6")
+	setupTestFlags(t, path, "--poll-interval", "1h")
+
+	if err := SetupConfig(); err != nil {
+		t.Fatalf("Failed testing SetupConfig()  Unexpected error: %v", err)
+	}
+	if Cfg.PollInterval != time.Hour {
+		t.Errorf("Failed testing SetupConfig() flag  Expected: %s  Got: %s", time.Hour, Cfg.PollInterval)
+	}
+	if Cfg.RequestInterval != 6*time.Second {
+		t.Errorf("Failed testing SetupConfig() env  Expected: %s  Got: %s", 6*time.Second, Cfg.RequestInterval)
+	}
+	if Cfg.CCXServer != "https://file.example.com" {
+		t.Errorf("Failed testing SetupConfig() file  Expected: %s  Got: %s", "https://file.example.com", Cfg.CCXServer)
+	}
+	if Cfg.HTTPTimeout != 90*time.Second {
+		t.Errorf("Failed testing SetupConfig() file  Expected: %s  Got: %s", 90*time.Second, Cfg.HTTPTimeout)
+	}
+	if Cfg.PodNamespace != DEFAULT_POD_NAMESPACE {
+		t.Errorf("Failed testing SetupConfig() default  Expected: %s  Got: %s", DEFAULT_POD_NAMESPACE, Cfg.PodNamespace)
+	}
+	if Cfg.RequestRate != 1 || Cfg.Workers != 1 {
+		t.Errorf("Failed testing SetupConfig() default  Expected: 1 and 1  Got: %v and %d", Cfg.RequestRate, Cfg.Workers)
+	}
+}
+
+// Should report every invalid setting and keep the previous configuration.
+func Test_SetupConfig_Invalid(t *testing.T) {
+
+	path := writeConfigFile(t, "ccxServer: not-a-url\nhttpTimeout: -1\n")
+	t.Setenv("POLL_INTERVAL", "abc")
+	setupTestFlags(t, path, "--request-interval", "x")
+	previous := Cfg
+
+	err := SetupConfig()
+	if err == nil {
+		t.Fatal("Failed testing SetupConfig()  Expected an error")
+	}
+	for _, setting := range []string{"POLL_INTERVAL", "CCX_SERVER", "HTTP_TIMEOUT", "--request-interval"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("Failed testing SetupConfig()  Expected an error for %s  Got: %v", setting, err)
+		}
+	}
+	if Cfg != previous {
+		t.Errorf("Failed testing SetupConfig()  Expected the previous configuration to be kept")
+	}
+}
+
+// Should read the request budget and the worker count, REQUEST_INTERVAL overrides REQUEST_RATE.
+func Test_SetupConfig_RequestRate(t *testing.T) {
+
+	setupTestFlags(t, writeConfigFile(t, "requestRate: 2.5\nretrievalWorkers: 4\n"))
+
+	if err := SetupConfig(); err != nil {
+		t.Fatalf("Failed testing SetupConfig()  Unexpected error: %v", err)
+	}
+	if Cfg.RequestsPerSecond() != 2.5 || Cfg.Workers != 4 {
+		t.Errorf("Failed testing SetupConfig() file  Expected: 2.5 and 4  Got: %v and %d", Cfg.RequestsPerSecond(), Cfg.Workers)
+	}
+
+	t.Setenv("REQUEST_INTERVAL", "500ms")
+	t.Setenv("RETRIEVAL_WORKERS", "0")
+	err := SetupConfig()
+	if err == nil || !strings.Contains(err.Error(), "RETRIEVAL_WORKERS") {
+		t.Errorf("Failed testing SetupConfig()  Expected an invalid RETRIEVAL_WORKERS error  Got: %v", err)
+	}
+	t.Setenv("RETRIEVAL_WORKERS", "2")
+	if err := SetupConfig(); err != nil || Cfg.RequestsPerSecond() != 2 {
+		t.Errorf("Failed testing SetupConfig() REQUEST_INTERVAL  Expected: 2  Got: %v %v", Cfg.RequestsPerSecond(), err)
+	}
+
+	t.Setenv("REQUEST_RATE", "fast")
+	err = SetupConfig()
+	if err == nil || !strings.Contains(err.Error(), "REQUEST_RATE") {
+		t.Errorf("Failed testing SetupConfig()  Expected an invalid REQUEST_RATE error  Got: %v", err)
+	}
+}
+
+// Should read the retry settings, reject a request sent less than once and a circuit breaker opening without failures.
+func Test_SetupConfig_Retries(t *testing.T) {
+
+	setupTestFlags(t, writeConfigFile(t, "ccxMaxAttempts: 6\nccxMaxBackoff: 30s\n"))
+
+	if err := SetupConfig(); err != nil {
+		t.Fatalf("Failed testing SetupConfig()  Unexpected error: %v", err)
+	}
+	if Cfg.MaxAttempts != 6 || Cfg.MaxBackoff != 30*time.Second {
+		t.Errorf("Failed testing SetupConfig() file  Expected: 6 and 30s  Got: %d and %s", Cfg.MaxAttempts, Cfg.MaxBackoff)
+	}
+
+	t.Setenv("CCX_MAX_ATTEMPTS", "-1")
+	err := SetupConfig()
+	if err == nil || !strings.Contains(err.Error(), "CCX_MAX_ATTEMPTS") {
+		t.Errorf("Failed testing SetupConfig()  Expected an invalid CCX_MAX_ATTEMPTS error  Got: %v", err)
+	}
+
+	t.Setenv("CIRCUIT_BREAKER_FAILURES", "0")
+	err = SetupConfig()
+	if err == nil || !strings.Contains(err.Error(), "CIRCUIT_BREAKER_FAILURES") {
+		t.Errorf("Failed testing SetupConfig()  Expected an invalid CIRCUIT_BREAKER_FAILURES error  Got: %v", err)
+	}
+}
+
+// Should read REPORT_EXPIRY in hours and reject a value that isn't positive.
+func Test_SetupConfig_ReportExpiry(t *testing.T) {
+
+	setupTestFlags(t, "")
+
+	if err := SetupConfig(); err != nil {
+		t.Fatalf("Failed testing SetupConfig()  Unexpected error: %v", err)
+	}
+	if Cfg.ReportExpiry != DEFAULT_REPORT_EXPIRY {
+		t.Errorf("Failed testing SetupConfig() default  Expected: %s  Got: %s", DEFAULT_REPORT_EXPIRY, Cfg.ReportExpiry)
+	}
+
+	t.Setenv("REPORT_EXPIRY", "48")
+	if err := SetupConfig(); err != nil || Cfg.ReportExpiry != 48*time.Hour {
+		t.Errorf("Failed testing SetupConfig() hours  Expected: 48h0m0s  Got: %s, %v", Cfg.ReportExpiry, err)
+	}
+
+	t.Setenv("REPORT_EXPIRY", "0")
+	err := SetupConfig()
+	if err == nil || !strings.Contains(err.Error(), "REPORT_EXPIRY") {
+		t.Errorf("Failed testing SetupConfig()  Expected an invalid REPORT_EXPIRY error  Got: %v", err)
+	}
+}
+
+// Should reject unknown keys in the config file.
+func Test_SetupConfig_UnknownKey(t *testing.T) {
+
+	setupTestFlags(t, writeConfigFile(t, "pollIntervall: 10\n"))
+
+	if err := SetupConfig(); err == nil || !strings.Contains(err.Error(), "pollIntervall") {
+		t.Errorf("Failed testing SetupConfig()  Expected an unknown field error  Got: %v", err)
+	}
+}
+
+// Should read plain integers in the unit of the setting and Go duration strings.
+func Test_ParseDuration(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		unit     time.Duration
+		expected time.Duration
+	}{
+		{"30", time.Minute, 30 * time.Minute},
+		{"180000", time.Millisecond, 3 * time.Minute},
+		{"15m", time.Minute, 15 * time.Minute},
+		{"500ms", time.Second, 500 * time.Millisecond},
+		{"1h30m", time.Second, 90 * time.Minute},
+	}
+	for _, test := range tests {
+		parsed, err := ParseDuration(test.value, test.unit)
+		if err != nil || parsed != test.expected {
+			t.Errorf("Failed testing ParseDuration(%q)  Expected: %s  Got: %s %v", test.value, test.expected, parsed, err)
+		}
+	}
+	if _, err := ParseDuration("15 minutes", time.Minute); err == nil {
+		t.Errorf("Failed testing ParseDuration()  Expected an error")
+	}
+}
+
+// Should report an invalid duration instead of using zero.
+func Test_SetDefaultDuration(t *testing.T) {
+
+	t.Setenv("TEST_DURATION", "2s")
+	var property time.Duration
+	if err := setDefaultDuration(&property, "TEST_DURATION", "m", time.Minute); err != nil || property != 2*time.Second {
+		t.Errorf("Failed testing setDefaultDuration()  Expected: %s  Got: %s %v", 2*time.Second, property, err)
+	}
+
+	t.Setenv("TEST_DURATION", "ten")
+	property = 0
+	err := setDefaultDuration(&property, "TEST_DURATION", "m", time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "minutes") || property != time.Minute {
+		t.Errorf("Failed testing setDefaultDuration()  Expected: error and %s  Got: %v and %s", time.Minute, err, property)
+	}
+}
+
+// Should report an invalid duration in the config file.
+func Test_SetupConfig_InvalidDuration(t *testing.T) {
+
+	setupTestFlags(t, writeConfigFile(t, "pollInterval: 15 minutes\nrequestInterval: 2\n"))
+
+	err := SetupConfig()
+	if err == nil || !strings.Contains(err.Error(), "pollInterval") {
+		t.Errorf("Failed testing SetupConfig()  Expected an invalid pollInterval error  Got: %v", err)
+	}
+}
+
+// Should reject CCX_TOKEN and CCX_TOKEN_FILE set together.
+func Test_SetupConfig_TokenAndTokenFile(t *testing.T) {
+
+	t.Setenv("CCX_TOKEN", "Bearer token")
+	t.Setenv("CCX_TOKEN_FILE", "/var/run/secrets/ccx/token")
+	t.Cleanup(func() { _ = SetupConfig() })
+
+	if err := SetupConfig(); err == nil || !strings.Contains(err.Error(), "CCX_TOKEN_FILE") {
+		t.Errorf("Failed testing SetupConfig()  Expected a CCX_TOKEN_FILE error  Got: %v", err)
+	}
+}
@@ -45,13 +45,17 @@ func GetDynamicClient() dynamic.Interface {
 	return dynamicClient
 }
 
+// Get the kubernetes clientset.
 func GetKubeClient() *kubernetes.Clientset {
+	mutex.Lock()
+	defer mutex.Unlock()
 	if clientSet != nil {
 		return clientSet
 	}
-	clientSet, err := kubernetes.NewForConfig(GetConfig())
+	newClientSet, err := kubernetes.NewForConfig(GetConfig())
 	if err != nil {
 		glog.Fatal("Cannot Construct ClientSet ", err)
 	}
+	clientSet = newClientSet
 	return clientSet
 }
@@ -0,0 +1,249 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigMapName - ConfigMap in the pod namespace overriding the configuration at runtime
+	ConfigMapName = "insights-client-config"
+	// ConfigMapKey - key of the ConfigMap holding the configuration, same format as the --config file
+	ConfigMapKey = "config.yaml"
+)
+
+// Settings applied to the running client on reload, changing the others requires a restart.
+// The ConfigMap can only set these, it is not read before the client starts.
+var reloadable = map[string]bool{
+	"PollInterval":    true,
+	"RequestInterval": true,
+	"RequestRate":     true,
+	"MaxAttempts":     true,
+	"MaxBackoff":      true,
+	"BreakerFailures": true,
+	"BreakerCooldown": true,
+	"ReportExpiry":    true,
+	"CCXServer":       true,
+}
+
+// Settings whose values are not logged
+var sensitive = map[string]bool{
+	"CCXToken": true,
+	"CACert":   true,
+}
+
+var (
+	reloadLock    sync.Mutex
+	configMapLock sync.RWMutex
+	configMapData string
+	listeners     []func(previous Config, current Config)
+
+	// Set from the InsightsClientConfig resource, guarded by configMapLock
+	resourceOverrides Config
+)
+
+// OnChange registers a function called with the previous and the new configuration when a reload changed it
+func OnChange(listener func(previous Config, current Config)) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	listeners = append(listeners, listener)
+}
+
+// Reload loads the configuration again, logs what changed and calls the OnChange functions.
+// The current configuration is kept if the new one is invalid.
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	previous, err := load()
+	if err != nil {
+		return err
+	}
+	current := Get()
+	changes := Diff(previous, current)
+	if len(changes) == 0 {
+		glog.Info("Configuration reloaded, nothing changed")
+		return nil
+	}
+	for _, change := range changes {
+		glog.Infof("Configuration changed: %s", change)
+	}
+	for _, listener := range listeners {
+		listener(previous, current)
+	}
+	return nil
+}
+
+// Diff describes each setting that changed between the two configurations
+func Diff(previous Config, current Config) []string {
+	var changes []string
+	previousValue := reflect.ValueOf(previous)
+	currentValue := reflect.ValueOf(current)
+	for i := 0; i < previousValue.NumField(); i++ {
+		field := previousValue.Type().Field(i)
+		before, after := previousValue.Field(i).Interface(), currentValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		change := fmt.Sprintf("%s: %v -> %v", field.Tag.Get("env"), before, after)
+		if sensitive[field.Name] {
+			change = field.Tag.Get("env") + " changed"
+		}
+		if !reloadable[field.Name] {
+			change += " (applied on restart with the --config file)"
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// WatchReloads reloads the configuration on SIGHUP and when the ConfigMap in the namespace changes,
+// until the context is cancelled
+func WatchReloads(ctx context.Context, client kubernetes.Interface, namespace string) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ConfigMapName).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			setConfigMap(obj)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			setConfigMap(next)
+		},
+		DeleteFunc: func(obj interface{}) {
+			setConfigMapData("")
+		},
+	})
+	if err != nil {
+		glog.Error("Error adding eventHandler for the configuration ConfigMap: ", err)
+	}
+	factory.Start(ctx.Done())
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				glog.Info("Received SIGHUP, reloading configuration")
+				logReloadErr(Reload())
+			}
+		}
+	}()
+}
+
+func setConfigMap(obj interface{}) {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	setConfigMapData(configMap.Data[ConfigMapKey])
+}
+
+// setConfigMapData reloads the configuration if the ConfigMap content changed
+func setConfigMapData(data string) {
+	configMapLock.Lock()
+	unchanged := data == configMapData
+	configMapData = data
+	configMapLock.Unlock()
+	if unchanged {
+		return
+	}
+	glog.Infof("ConfigMap %s changed, reloading configuration", ConfigMapName)
+	logReloadErr(Reload())
+}
+
+func logReloadErr(err error) {
+	if err != nil {
+		glog.Errorf("Invalid configuration, keeping the current one:\n%v", err)
+	}
+}
+
+// SetResourceOverrides overrides the settings with the non-zero fields of the InsightsClientConfig resource
+// and reloads the configuration. The previous overrides are restored if the configuration is invalid.
+func SetResourceOverrides(overrides Config) error {
+	configMapLock.Lock()
+	previous := resourceOverrides
+	resourceOverrides = overrides
+	configMapLock.Unlock()
+	if previous == overrides {
+		return nil
+	}
+	if err := Reload(); err != nil {
+		configMapLock.Lock()
+		resourceOverrides = previous
+		configMapLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// applyConfigMap overrides the settings set in the ConfigMap, then the ones set in the InsightsClientConfig resource
+func applyConfigMap(cfg *Config) error {
+	configMapLock.RLock()
+	data := configMapData
+	fromResource := resourceOverrides
+	configMapLock.RUnlock()
+	if data != "" {
+		overrides := Config{}
+		if err := yaml.UnmarshalStrict([]byte(data), &overrides); err != nil {
+			return fmt.Errorf("could not parse ConfigMap %s: %v", ConfigMapName, err)
+		}
+		if ignored := dropNotReloadable(&overrides); len(ignored) > 0 {
+			glog.Warningf("Ignoring %s in ConfigMap %s, they can't be changed at runtime: "+
+				"set them in the environment or the --config file", strings.Join(ignored, ", "), ConfigMapName)
+		}
+		mergeOverrides(cfg, overrides)
+	}
+	mergeOverrides(cfg, fromResource)
+	return nil
+}
+
+// dropNotReloadable clears the settings that are not reloadable and returns their names
+func dropNotReloadable(overrides *Config) []string {
+	var dropped []string
+	value := reflect.ValueOf(overrides).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if reloadable[field.Name] || value.Field(i).IsZero() {
+			continue
+		}
+		dropped = append(dropped, field.Tag.Get("env"))
+		value.Field(i).Set(reflect.Zero(field.Type))
+	}
+	return dropped
+}
+
+// mergeOverrides sets the non-zero fields of the overrides on the config
+func mergeOverrides(cfg *Config, overrides Config) {
+	value := reflect.ValueOf(cfg).Elem()
+	overridesValue := reflect.ValueOf(overrides)
+	for i := 0; i < value.NumField(); i++ {
+		if !overridesValue.Field(i).IsZero() {
+			value.Field(i).Set(overridesValue.Field(i))
+		}
+	}
+}
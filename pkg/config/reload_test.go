@@ -0,0 +1,105 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_Diff(t *testing.T) {
+
+	previous := Config{PollInterval: 30 * time.Minute, CCXToken: "secret", ServicePort: ":3030"}
+	current := Config{PollInterval: 10 * time.Minute, CCXToken: "other", ServicePort: ":4040"}
+	changes := strings.Join(Diff(previous, current), "\n")
+
+	for _, expected := range []string{"POLL_INTERVAL: 30m0s -> 10m0s", "CCX_TOKEN changed", "SERVICE_PORT: :3030 -> :4040 (applied on restart with the --config file)"} {
+		if !strings.Contains(changes, expected) {
+			t.Errorf("Failed testing Diff()  Expected: %s  Got: %s", expected, changes)
+		}
+	}
+	if strings.Contains(changes, "secret") {
+		t.Errorf("Failed testing Diff()  Expected the token to be hidden  Got: %s", changes)
+	}
+	if len(Diff(previous, previous)) != 0 {
+		t.Errorf("Failed testing Diff()  Expected no change for the same configuration")
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Should apply the ConfigMap changes, ignore an invalid ConfigMap and the settings that are not reloadable.
+func Test_WatchReloads_ConfigMap(t *testing.T) {
+
+	if err := SetupConfig(); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan Config, 10)
+	OnChange(func(previous Config, current Config) {
+		changed <- current
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.Cleanup(func() {
+		configMapData = ""
+		_ = SetupConfig()
+	})
+
+	client := fake.NewSimpleClientset()
+	WatchReloads(ctx, client, "test-namespace")
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: "test-namespace"},
+		Data: map[string]string{
+			ConfigMapKey: "pollInterval: 5\nccxServer: https://ccx.example.com\nservicePort: \":4040\"\n",
+		},
+	}
+	_, err := client.CoreV1().ConfigMaps("test-namespace").Create(ctx, configMap, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return Get().PollInterval == 5*time.Minute }, "Failed testing WatchReloads()  ConfigMap not applied")
+	current := <-changed
+	if current.CCXServer != "https://ccx.example.com" {
+		t.Errorf("Failed testing WatchReloads()  Expected: %s  Got: %s", "https://ccx.example.com", current.CCXServer)
+	}
+	if current.ServicePort != DEFAULT_SERVICE_PORT {
+		t.Errorf("Failed testing WatchReloads()  Expected the ConfigMap service port to be ignored  Got: %s",
+			current.ServicePort)
+	}
+
+	configMap.Data[ConfigMapKey] = "pollInterval: -1\n"
+	_, err = client.CoreV1().ConfigMaps("test-namespace").Update(ctx, configMap, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		configMapLock.RLock()
+		defer configMapLock.RUnlock()
+		return configMapData == "pollInterval: -1\n"
+	}, "Failed testing WatchReloads()  ConfigMap update not received")
+	if Get().PollInterval != 5*time.Minute {
+		t.Errorf("Failed testing WatchReloads()  Expected the invalid ConfigMap to be ignored  Got: %s", Get().PollInterval)
+	}
+
+	err = client.CoreV1().ConfigMaps("test-namespace").Delete(ctx, ConfigMapName, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return Get().PollInterval == DEFAULT_POLL_INTERVAL },
+		"Failed testing WatchReloads()  Expected the default after the ConfigMap is deleted")
+}
@@ -0,0 +1,133 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// TokenSecretAnnotation - ManagedCluster annotation naming the secret with the credentials of the cluster
+	// in POD_NAMESPACE, as name or POD_NAMESPACE/name. Secrets of other namespaces are rejected, the client
+	// can read them but the users allowed to annotate a ManagedCluster may not.
+	TokenSecretAnnotation = "insights.open-cluster-management.io/ccx-token-secret"
+	// ClusterSetLabel - label of the ManagedClusters in a ManagedClusterSet
+	ClusterSetLabel = "cluster.open-cluster-management.io/clusterset"
+	// DefaultCredentials - name of the hub credentials, used for the clusters without other credentials
+	DefaultCredentials = "default"
+)
+
+// Mapping selects the clusters of an organization, they use the credentials of the secret
+type Mapping struct {
+	Name     string
+	Selector labels.Selector
+	Secret   ktypes.NamespacedName
+}
+
+// ClusterCredentials chooses the TokenProvider of each cluster: the secret of the TokenSecretAnnotation,
+// then the secret of the first Mapping selecting the cluster labels, then the hub credentials
+type ClusterCredentials struct {
+	lock      sync.Mutex
+	fallback  TokenProvider
+	client    kubernetes.Interface
+	namespace string
+	metadata  func(name string) (map[string]string, map[string]string)
+	mappings  []Mapping
+	providers map[ktypes.NamespacedName]*SecretProvider // keeps the tokens of each secret cached
+	unmatched map[string]bool                           // clusters already logged as matching no mapping
+}
+
+// NewClusterCredentials uses the fallback for every cluster until mappings are set. metadata returns the labels
+// and annotations of a ManagedCluster, secrets without namespace are read in namespace.
+func NewClusterCredentials(fallback TokenProvider, client kubernetes.Interface, namespace string,
+	metadata func(name string) (map[string]string, map[string]string)) *ClusterCredentials {
+	return &ClusterCredentials{
+		fallback:  fallback,
+		client:    client,
+		namespace: namespace,
+		metadata:  metadata,
+		providers: map[ktypes.NamespacedName]*SecretProvider{},
+		unmatched: map[string]bool{},
+	}
+}
+
+// SetMappings replaces the mappings, the first mapping selecting a cluster is used
+func (c *ClusterCredentials) SetMappings(mappings []Mapping) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.mappings = mappings
+	c.unmatched = map[string]bool{}
+}
+
+// ForCluster returns the provider of the cluster and the name of its credentials
+func (c *ClusterCredentials) ForCluster(name string) (TokenProvider, string) {
+	clusterLabels, annotations := c.metadata(name)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ref := annotations[TokenSecretAnnotation]; ref != "" {
+		secret, err := c.parseSecretRef(ref)
+		if err != nil {
+			return &rejectedProvider{err: err}, "secret " + ref
+		}
+		return c.provider(secret), "secret " + secret.String()
+	}
+	for _, mapping := range c.mappings {
+		if mapping.Selector.Matches(labels.Set(clusterLabels)) {
+			return c.provider(mapping.Secret), mapping.Name
+		}
+	}
+	if len(c.mappings) > 0 && !c.unmatched[name] {
+		c.unmatched[name] = true
+		glog.Warningf("Cluster %s matches no credentials of the InsightsClientConfig, using the %s credentials",
+			name, DefaultCredentials)
+	}
+	return c.fallback, DefaultCredentials
+}
+
+// CredentialsName returns the name of the credentials of the cluster, DefaultCredentials when the cluster
+// has no annotation and matches no mapping
+func (c *ClusterCredentials) CredentialsName(name string) string {
+	_, credentialsName := c.ForCluster(name)
+	return credentialsName
+}
+
+// parseSecretRef reads name or namespace/name, the secret must be in the client namespace
+func (c *ClusterCredentials) parseSecretRef(ref string) (ktypes.NamespacedName, error) {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found {
+		return ktypes.NamespacedName{Namespace: c.namespace, Name: ref}, nil
+	}
+	if namespace != c.namespace {
+		return ktypes.NamespacedName{}, fmt.Errorf("the %s annotation can only name a secret in the %s namespace, not %s",
+			TokenSecretAnnotation, c.namespace, ref)
+	}
+	return ktypes.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// rejectedProvider fails the requests of a cluster whose annotation names a secret that can't be used
+type rejectedProvider struct {
+	err error
+}
+
+func (p *rejectedProvider) Token(ctx context.Context) (string, error) {
+	return "", p.err
+}
+
+func (p *rejectedProvider) Invalidate() {}
+
+func (c *ClusterCredentials) provider(secret ktypes.NamespacedName) *SecretProvider {
+	if provider, ok := c.providers[secret]; ok {
+		return provider
+	}
+	provider := NewSecretProvider(c.client, secret.Namespace, secret.Name)
+	c.providers[secret] = provider
+	return provider
+}
@@ -0,0 +1,81 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/labels"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ClusterCredentials_ForCluster(t *testing.T) {
+	clusterLabels := map[string]map[string]string{
+		"cluster-a":  {ClusterSetLabel: "org-a", "vendor": "OpenShift"},
+		"cluster-b":  {"org": "b"},
+		"annotated":  {ClusterSetLabel: "org-a"},
+		"annotated2": {},
+		"foreign":    {},
+		"hub":        {"vendor": "OpenShift"},
+	}
+	annotations := map[string]map[string]string{
+		"annotated":  {TokenSecretAnnotation: "open-cluster-management/org-c"},
+		"annotated2": {TokenSecretAnnotation: "org-d"},
+		"foreign":    {TokenSecretAnnotation: "kube-system/admin-token"},
+	}
+	fallback := NewStaticProvider("Bearer hub")
+	c := NewClusterCredentials(fallback, fake.NewSimpleClientset(), "open-cluster-management",
+		func(name string) (map[string]string, map[string]string) {
+			return clusterLabels[name], annotations[name]
+		},
+	)
+
+	provider, name := c.ForCluster("cluster-a")
+	assert.Equal(t, fallback, provider, "Test hub credentials used without mappings")
+	assert.Equal(t, DefaultCredentials, name)
+
+	c.SetMappings([]Mapping{
+		{
+			Name:     "org-a",
+			Selector: labels.SelectorFromSet(labels.Set{ClusterSetLabel: "org-a"}),
+			Secret:   ktypes.NamespacedName{Namespace: "open-cluster-management", Name: "org-a"},
+		},
+		{
+			Name:     "org-b",
+			Selector: labels.SelectorFromSet(labels.Set{"org": "b"}),
+			Secret:   ktypes.NamespacedName{Namespace: "open-cluster-management", Name: "org-b"},
+		},
+	})
+
+	provider, name = c.ForCluster("cluster-a")
+	assert.Equal(t, "org-a", name, "Test cluster set mapping")
+	assert.Equal(t, "org-a", provider.(*SecretProvider).name)
+	again, _ := c.ForCluster("cluster-a")
+	assert.Same(t, provider, again, "Test provider reused so the token stays cached")
+
+	_, name = c.ForCluster("cluster-b")
+	assert.Equal(t, "org-b", name, "Test label mapping")
+
+	provider, name = c.ForCluster("annotated")
+	assert.Equal(t, "secret open-cluster-management/org-c", name, "Test annotation takes precedence over the mappings")
+	assert.Equal(t, "org-c", provider.(*SecretProvider).name)
+
+	provider, _ = c.ForCluster("annotated2")
+	assert.Equal(t, "open-cluster-management", provider.(*SecretProvider).namespace,
+		"Test annotation without namespace uses the client namespace")
+
+	provider, name = c.ForCluster("foreign")
+	assert.Equal(t, "secret kube-system/admin-token", name)
+	_, err := provider.Token(context.TODO())
+	assert.ErrorContains(t, err, "can only name a secret in the open-cluster-management namespace",
+		"Test annotation naming a secret of another namespace rejected")
+
+	provider, name = c.ForCluster("hub")
+	assert.Equal(t, fallback, provider, "Test hub credentials used for clusters without mapping")
+	assert.Equal(t, DefaultCredentials, name)
+	assert.Equal(t, DefaultCredentials, c.CredentialsName("hub"), "Test cluster without credentials reported")
+	assert.Equal(t, "org-b", c.CredentialsName("cluster-b"))
+}
@@ -0,0 +1,64 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// How long the command token is used before the command is run again
+	execMaxAge = 5 * time.Minute
+	// The command is killed if it takes longer
+	execTimeout = 30 * time.Second
+)
+
+// ExecProvider returns the output of a command, in the same format as CCX_TOKEN.
+// The command is run without a shell, its arguments are separated by spaces.
+type ExecProvider struct {
+	command []string
+	cache   *cachedToken
+}
+
+// NewExecProvider returns an error if the command is empty
+func NewExecProvider(command string) (*ExecProvider, error) {
+	p := &ExecProvider{command: strings.Fields(command)}
+	if len(p.command) == 0 {
+		return nil, fmt.Errorf("CCX_TOKEN_EXEC is empty")
+	}
+	p.cache = &cachedToken{source: "command " + p.command[0], fetch: p.fetch}
+	return p, nil
+}
+
+// Token returns the command output, the command is run again every 5 minutes
+func (p *ExecProvider) Token(ctx context.Context) (string, error) {
+	return p.cache.get(ctx)
+}
+
+// Invalidate runs the command again on the next Token call
+func (p *ExecProvider) Invalidate() {
+	p.cache.invalidate()
+}
+
+func (p *ExecProvider) fetch(ctx context.Context) (fetchedToken, error) {
+	token, err := p.run(ctx)
+	return fetchedToken{value: token, refreshAt: time.Now().Add(execMaxAge)}, err
+}
+
+func (p *ExecProvider) run(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, execTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("CCX_TOKEN_EXEC command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseToken(output, "CCX_TOKEN_EXEC output")
+}
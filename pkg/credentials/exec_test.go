@@ -0,0 +1,26 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExecProvider(t *testing.T) {
+	provider, err := NewExecProvider("echo Bearer exec")
+	assert.Nil(t, err)
+	token, err := provider.Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer exec", token, "Test command output used")
+
+	provider, err = NewExecProvider("false")
+	assert.Nil(t, err)
+	_, err = provider.Token(context.TODO())
+	assert.NotNil(t, err, "Test failing command returns an error")
+
+	_, err = NewExecProvider("  ")
+	assert.NotNil(t, err, "Test empty command rejected")
+}
@@ -0,0 +1,68 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// How long the file token is used before the file is read again. The kubelet updates mounted
+// secrets by swapping a symlink, reading the content catches it without watching the directory.
+const fileMaxAge = 30 * time.Second
+
+// FileProvider returns the token in a file, e.g. a mounted secret, in the same format as CCX_TOKEN
+type FileProvider struct {
+	path  string
+	cache *cachedToken
+}
+
+// NewFileProvider returns an error if the file doesn't hold a valid token
+func NewFileProvider(path string) (*FileProvider, error) {
+	p := &FileProvider{path: path}
+	p.cache = &cachedToken{source: "file " + path, fetch: p.fetch}
+	if _, err := p.Token(context.TODO()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Token returns the file token, the file is read again every 30 seconds.
+// An unreadable or invalid file keeps the current token.
+func (p *FileProvider) Token(ctx context.Context) (string, error) {
+	return p.cache.get(ctx)
+}
+
+// Invalidate reads the file again on the next Token call
+func (p *FileProvider) Invalidate() {
+	p.cache.invalidate()
+}
+
+func (p *FileProvider) fetch(ctx context.Context) (fetchedToken, error) {
+	token, err := ReadTokenFile(p.path)
+	return fetchedToken{value: token, refreshAt: time.Now().Add(fileMaxAge)}, err
+}
+
+// ReadTokenFile returns the token in the file, in the same format as CCX_TOKEN
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read CCX_TOKEN_FILE: %v", err)
+	}
+	return parseToken(data, "CCX_TOKEN_FILE "+path)
+}
+
+// parseToken trims the token and rejects empty and multi-line values
+func parseToken(data []byte, source string) (string, error) {
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", source)
+	}
+	if strings.ContainsAny(token, "\r\n") {
+		return "", fmt.Errorf("%s is not valid: contains newlines", source)
+	}
+	return token, nil
+}
@@ -0,0 +1,52 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReadTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(path, []byte("Bearer first\n"), 0600))
+	token, err := ReadTokenFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer first", token, "Test trailing newline trimmed")
+
+	assert.Nil(t, os.WriteFile(path, []byte("  \n"), 0600))
+	_, err = ReadTokenFile(path)
+	assert.NotNil(t, err, "Test empty file rejected")
+
+	assert.Nil(t, os.WriteFile(path, []byte("Bearer a\nBearer b"), 0600))
+	_, err = ReadTokenFile(path)
+	assert.NotNil(t, err, "Test token with newlines rejected")
+
+	_, err = ReadTokenFile(filepath.Join(t.TempDir(), "missing"))
+	assert.NotNil(t, err, "Test missing file rejected")
+}
+
+func Test_FileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(path, []byte("Bearer first"), 0600))
+	provider, err := NewFileProvider(path)
+	assert.Nil(t, err)
+
+	// A rotated secret is read once the cached token is invalidated or too old
+	assert.Nil(t, os.WriteFile(path, []byte("Bearer second\n"), 0600))
+	provider.Invalidate()
+	token, err := provider.Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer second", token, "Test rotated token used")
+
+	assert.Nil(t, os.Remove(path))
+	provider.cache.refreshAt = time.Now().Add(-time.Second)
+	token, err = provider.Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer second", token, "Test current token kept when the file is missing")
+}
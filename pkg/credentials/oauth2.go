@@ -0,0 +1,112 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/oauth2/clientcredentials"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Keys of the client ID and secret in the CCX_OAUTH_SECRET secret
+const (
+	OAuth2ClientIDKey     = "client_id"
+	OAuth2ClientSecretKey = "client_secret"
+)
+
+const (
+	// Access tokens are replaced this long before they expire
+	oauth2RefreshMargin = time.Minute
+	// How long an access token without expiry is used
+	oauth2DefaultMaxAge = 5 * time.Minute
+)
+
+// OAuth2Provider exchanges the client ID and secret of a Kubernetes Secret for short-lived access tokens,
+// e.g. a console.redhat.com service account
+type OAuth2Provider struct {
+	client     kubernetes.Interface
+	namespace  string
+	secretName string
+	tokenURL   string
+	scopes     []string
+	cache      *cachedToken
+}
+
+// NewOAuth2Provider ...
+func NewOAuth2Provider(client kubernetes.Interface, namespace string, secretName string, tokenURL string,
+	scopes []string) *OAuth2Provider {
+	p := &OAuth2Provider{
+		client:     client,
+		namespace:  namespace,
+		secretName: secretName,
+		tokenURL:   tokenURL,
+		scopes:     scopes,
+	}
+	p.cache = &cachedToken{source: "token URL " + tokenURL, fetch: p.fetch}
+	return p
+}
+
+// Token returns the access token, a new one is requested shortly before it expires
+func (p *OAuth2Provider) Token(ctx context.Context) (string, error) {
+	return p.cache.get(ctx)
+}
+
+// Invalidate requests a new access token on the next Token call, the secret is read again
+func (p *OAuth2Provider) Invalidate() {
+	p.cache.invalidate()
+}
+
+func (p *OAuth2Provider) fetch(ctx context.Context) (fetchedToken, error) {
+	secret, err := p.client.CoreV1().Secrets(p.namespace).Get(ctx, p.secretName, metav1.GetOptions{})
+	if err != nil {
+		return fetchedToken{}, fmt.Errorf("could not get the OAuth2 client secret %s/%s: %v", p.namespace, p.secretName, err)
+	}
+	clientID := strings.TrimSpace(string(secret.Data[OAuth2ClientIDKey]))
+	clientSecret := strings.TrimSpace(string(secret.Data[OAuth2ClientSecretKey]))
+	if clientID == "" || clientSecret == "" {
+		return fetchedToken{}, fmt.Errorf("secret %s/%s must have the %s and %s keys",
+			p.namespace, p.secretName, OAuth2ClientIDKey, OAuth2ClientSecretKey)
+	}
+	return exchange(ctx, clientID, clientSecret, p.tokenURL, p.scopes)
+}
+
+// exchange requests an access token with the client credentials grant,
+// returns the Authorization header value, when to replace it and when it expires
+func exchange(ctx context.Context, clientID string, clientSecret string, tokenURL string,
+	scopes []string) (fetchedToken, error) {
+	clientCredentials := clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       scopes,
+	}
+	token, err := clientCredentials.Token(ctx)
+	if err != nil {
+		return fetchedToken{}, fmt.Errorf("could not get an OAuth2 access token for client %s: %v", clientID, err)
+	}
+	glog.V(2).Infof("Got an OAuth2 access token for client %s expiring at %s", clientID, token.Expiry)
+	return fetchedToken{
+		value:     token.Type() + " " + token.AccessToken,
+		refreshAt: refreshAt(token.Expiry, time.Now()),
+		expiresAt: token.Expiry,
+	}, nil
+}
+
+// refreshAt returns when an access token expiring at expiry is replaced:
+// oauth2RefreshMargin before it expires, or halfway through shorter lifetimes
+func refreshAt(expiry time.Time, now time.Time) time.Time {
+	if expiry.IsZero() {
+		return now.Add(oauth2DefaultMaxAge)
+	}
+	lifetime := expiry.Sub(now)
+	if lifetime < 2*oauth2RefreshMargin {
+		return now.Add(lifetime / 2)
+	}
+	return expiry.Add(-oauth2RefreshMargin)
+}
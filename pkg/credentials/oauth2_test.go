@@ -0,0 +1,100 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newClientSecret(clientSecret string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ccx-oauth", Namespace: "open-cluster-management"},
+		Data: map[string][]byte{
+			OAuth2ClientIDKey:     []byte("service-account"),
+			OAuth2ClientSecretKey: []byte(clientSecret),
+		},
+	}
+}
+
+// newTokenServer returns a stub token endpoint issuing access-1, access-2... to the client with the secret
+func newTokenServer(t *testing.T, clientSecret *string) (*httptest.Server, *int) {
+	issued := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"), "Test client credentials grant")
+		assert.Equal(t, "api.console", r.PostForm.Get("scope"), "Test scope sent")
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if id != "service-account" || secret != *clientSecret {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprint(w, `{"error": "unauthorized_client"}`)
+			return
+		}
+		issued++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": "access-%d", "token_type": "Bearer", "expires_in": 300}`, issued)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &issued
+}
+
+func Test_OAuth2Provider(t *testing.T) {
+	clientSecret := "first"
+	ts, issued := newTokenServer(t, &clientSecret)
+	client := fake.NewSimpleClientset(newClientSecret("first"))
+	provider := NewOAuth2Provider(client, "open-cluster-management", "ccx-oauth", ts.URL, []string{"api.console"})
+
+	token, err := provider.Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer access-1", token, "Test access token used")
+	token, _ = provider.Token(context.TODO())
+	assert.Equal(t, "Bearer access-1", token, "Test access token cached")
+	assert.Equal(t, 1, *issued, "Test one token request")
+	assert.WithinDuration(t, time.Now().Add(4*time.Minute), provider.cache.refreshAt, 5*time.Second,
+		"Test token replaced one minute before it expires")
+
+	// The client secret is rotated, the next token is requested with the new one
+	clientSecret = "second"
+	_, err = client.CoreV1().Secrets("open-cluster-management").Update(context.TODO(), newClientSecret("second"),
+		metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	provider.Invalidate()
+	token, err = provider.Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer access-2", token, "Test new access token after Invalidate")
+}
+
+func Test_OAuth2Provider_Errors(t *testing.T) {
+	clientSecret := "expected"
+	ts, _ := newTokenServer(t, &clientSecret)
+
+	provider := NewOAuth2Provider(fake.NewSimpleClientset(), "open-cluster-management", "ccx-oauth", ts.URL,
+		[]string{"api.console"})
+	_, err := provider.Token(context.TODO())
+	assert.NotNil(t, err, "Test missing secret")
+
+	provider = NewOAuth2Provider(fake.NewSimpleClientset(newClientSecret("wrong")), "open-cluster-management",
+		"ccx-oauth", ts.URL, []string{"api.console"})
+	_, err = provider.Token(context.TODO())
+	assert.NotNil(t, err, "Test rejected client credentials")
+}
+
+func Test_refreshAt(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, now.Add(oauth2DefaultMaxAge), refreshAt(time.Time{}, now), "Test token without expiry")
+	assert.Equal(t, now.Add(59*time.Minute), refreshAt(now.Add(time.Hour), now), "Test refresh margin")
+	assert.Equal(t, now.Add(30*time.Second), refreshAt(now.Add(time.Minute), now), "Test short lifetime")
+}
@@ -0,0 +1,138 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TokenProvider returns the Authorization header value sent to CCX.
+// Implement it to get the token from another credential source.
+type TokenProvider interface {
+	// Token returns the current token, the context bounds the calls needed to get a new one
+	Token(ctx context.Context) (string, error)
+	// Invalidate is called when CCX rejects the token, the next Token call gets a new one
+	Invalidate()
+}
+
+// NewProvider returns the provider selected by the configuration: CCX_OAUTH_SECRET,
+// CCX_TOKEN_EXEC, CCX_TOKEN_FILE, CCX_TOKEN, or the cluster pull-secret when none is set
+func NewProvider(cfg config.Config, kubeClient kubernetes.Interface) (TokenProvider, error) {
+	switch {
+	case cfg.CCXOAuthSecret != "":
+		glog.Infof("Getting the CCX token from %s with the OAuth2 client of the secret %s/%s",
+			cfg.CCXTokenURL, cfg.PodNamespace, cfg.CCXOAuthSecret)
+		return NewOAuth2Provider(kubeClient, cfg.PodNamespace, cfg.CCXOAuthSecret, cfg.CCXTokenURL,
+			strings.Fields(cfg.CCXOAuthScopes)), nil
+	case cfg.CCXTokenExec != "":
+		glog.Infof("Getting the CCX token from the command %s", cfg.CCXTokenExec)
+		return NewExecProvider(cfg.CCXTokenExec)
+	case cfg.CCXTokenFile != "":
+		glog.Infof("Getting the CCX token from the file %s", cfg.CCXTokenFile)
+		return NewFileProvider(cfg.CCXTokenFile)
+	case cfg.CCXToken != "":
+		glog.Info("Using the CCX token from CCX_TOKEN")
+		return NewStaticProvider(cfg.CCXToken), nil
+	default:
+		glog.Info("Getting the CCX token from the cluster pull-secret")
+		return NewPullSecretProvider(kubeClient), nil
+	}
+}
+
+// StaticProvider always returns the same token, e.g. CCX_TOKEN
+type StaticProvider struct {
+	token string
+}
+
+// NewStaticProvider ...
+func NewStaticProvider(token string) *StaticProvider {
+	return &StaticProvider{token: token}
+}
+
+// Token returns the static token
+func (p *StaticProvider) Token(ctx context.Context) (string, error) {
+	return p.token, nil
+}
+
+// Invalidate does nothing, there is no other token to use
+func (p *StaticProvider) Invalidate() {
+	glog.Warning("CCX rejected the CCX_TOKEN, it must be replaced")
+}
+
+// How long a failed refresh is not retried, the previous token or the error is returned meanwhile
+const tokenRetryInterval = 30 * time.Second
+
+// fetchedToken is returned by the fetch function of a cachedToken
+type fetchedToken struct {
+	value     string
+	refreshAt time.Time // when the token is fetched again
+	expiresAt time.Time // the token is not used after it expires, never expires when zero
+}
+
+// cachedToken keeps the fetched token until its refresh time or until it is invalidated.
+// The current token is kept if a refresh fails before it is invalidated or expires.
+type cachedToken struct {
+	lock      sync.Mutex
+	source    string
+	fetch     func(ctx context.Context) (fetchedToken, error)
+	token     string
+	refreshAt time.Time // when the token is fetched again
+	expiresAt time.Time // when the token expires, zero if it doesn't
+	err       error     // error of the last refresh, returned until refreshAt when there is no token
+}
+
+func (c *cachedToken) get(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if !c.expiresAt.IsZero() && !now.Before(c.expiresAt) {
+		// CCX rejects an expired token, never send it
+		c.token = ""
+	}
+	if now.Before(c.refreshAt) {
+		if c.token != "" {
+			return c.token, nil
+		}
+		if c.err != nil {
+			return "", c.err
+		}
+	}
+	fetched, err := c.fetch(ctx)
+	metrics.TokenRefreshes.WithLabelValues(metrics.ResultLabel(err)).Inc()
+	if err != nil {
+		// Don't call the source again on every CCX request while it is failing
+		c.refreshAt = time.Now().Add(tokenRetryInterval)
+		c.err = err
+		if c.token == "" {
+			return "", err
+		}
+		glog.Warningf("Unable to refresh the CCX token from the %s, using previous token: %v", c.source, err)
+		return c.token, nil
+	}
+	c.err = nil
+	token := fetched.value
+	if token != c.token {
+		glog.Infof("CCX token loaded from the %s", c.source)
+	}
+	c.token = token
+	c.refreshAt = fetched.refreshAt
+	c.expiresAt = fetched.expiresAt
+	return token, nil
+}
+
+func (c *cachedToken) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	glog.Infof("CCX token from the %s invalidated", c.source)
+	c.token = ""
+	c.err = nil
+	c.refreshAt = time.Time{}
+}
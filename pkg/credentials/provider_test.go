@@ -0,0 +1,98 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_NewProvider(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	provider, err := NewProvider(config.Config{}, client)
+	assert.Nil(t, err)
+	assert.IsType(t, &PullSecretProvider{}, provider, "Test pull-secret used by default")
+
+	provider, err = NewProvider(config.Config{CCXToken: "Bearer static"}, client)
+	assert.Nil(t, err)
+	token, _ := provider.Token(context.TODO())
+	assert.Equal(t, "Bearer static", token, "Test CCX_TOKEN used")
+
+	provider, err = NewProvider(config.Config{CCXTokenExec: "echo Bearer exec"}, client)
+	assert.Nil(t, err)
+	assert.IsType(t, &ExecProvider{}, provider, "Test CCX_TOKEN_EXEC used")
+
+	provider, err = NewProvider(config.Config{CCXOAuthSecret: "ccx-oauth", CCXTokenURL: "https://sso.example.com/token"}, client)
+	assert.Nil(t, err)
+	assert.IsType(t, &OAuth2Provider{}, provider, "Test CCX_OAUTH_SECRET used")
+
+	_, err = NewProvider(config.Config{CCXTokenFile: filepath.Join(t.TempDir(), "missing")}, client)
+	assert.NotNil(t, err, "Test missing CCX_TOKEN_FILE rejected")
+}
+
+func Test_cachedToken(t *testing.T) {
+	fetches := 0
+	var fetchErr error
+	cache := &cachedToken{source: "test", fetch: func(ctx context.Context) (fetchedToken, error) {
+		fetches++
+		return fetchedToken{value: "Bearer token", refreshAt: time.Now().Add(time.Hour)}, fetchErr
+	}}
+
+	token, err := cache.get(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", token)
+	_, _ = cache.get(context.TODO())
+	assert.Equal(t, 1, fetches, "Test token cached")
+
+	cache.refreshAt = time.Now().Add(-time.Minute)
+	fetchErr = errors.New("unavailable")
+	token, err = cache.get(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", token, "Test previous token kept when the refresh fails")
+
+	assert.Equal(t, 2, fetches)
+	_, _ = cache.get(context.TODO())
+	assert.Equal(t, 2, fetches, "Test failed refresh not retried right away")
+
+	cache.invalidate()
+	_, err = cache.get(context.TODO())
+	assert.NotNil(t, err, "Test invalidated token not used")
+	assert.Equal(t, 3, fetches, "Test token fetched again")
+	_, err = cache.get(context.TODO())
+	assert.NotNil(t, err, "Test error returned until the retry")
+	assert.Equal(t, 3, fetches, "Test failing source not called on every request")
+
+	cache.refreshAt = time.Now().Add(-time.Second)
+	fetchErr = nil
+	token, err = cache.get(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", token, "Test token fetched after the retry interval")
+}
+
+// An expired token is not returned when the refresh fails
+func Test_cachedToken_Expired(t *testing.T) {
+	fetchErr := errors.New("unavailable")
+	cache := &cachedToken{source: "test", fetch: func(ctx context.Context) (fetchedToken, error) {
+		return fetchedToken{}, fetchErr
+	}}
+	cache.token = "Bearer access"
+	cache.refreshAt = time.Now().Add(-time.Minute)
+	cache.expiresAt = time.Now().Add(time.Minute)
+	token, err := cache.get(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer access", token, "Test token kept until it expires")
+
+	cache.refreshAt = time.Now().Add(-time.Minute)
+	cache.expiresAt = time.Now().Add(-time.Second)
+	_, err = cache.get(context.TODO())
+	assert.Equal(t, fetchErr, err, "Test expired token not returned")
+	_, err = cache.get(context.TODO())
+	assert.Equal(t, fetchErr, err, "Test expired token not returned until the retry")
+}
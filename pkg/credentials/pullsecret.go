@@ -0,0 +1,93 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// How long the pull-secret token is used before the secret is read again
+const pullSecretMaxAge = 5 * time.Minute
+
+type serializedAuthMap struct {
+	Auths map[string]serializedAuth `json:"auths"`
+}
+type serializedAuth struct {
+	Auth string `json:"auth"`
+}
+
+// PullSecretProvider returns the cloud.openshift.com token of the openshift-config/pull-secret secret
+type PullSecretProvider struct {
+	client kubernetes.Interface
+	cache  *cachedToken
+}
+
+// NewPullSecretProvider ...
+func NewPullSecretProvider(client kubernetes.Interface) *PullSecretProvider {
+	p := &PullSecretProvider{client: client}
+	p.cache = &cachedToken{source: "pull-secret", fetch: p.fetch}
+	return p
+}
+
+// Token returns the pull-secret token, the secret is read again every 5 minutes
+func (p *PullSecretProvider) Token(ctx context.Context) (string, error) {
+	return p.cache.get(ctx)
+}
+
+// Invalidate reads the secret again on the next Token call
+func (p *PullSecretProvider) Invalidate() {
+	p.cache.invalidate()
+}
+
+func (p *PullSecretProvider) fetch(ctx context.Context) (fetchedToken, error) {
+	token, err := p.readSecret(ctx)
+	return fetchedToken{value: token, refreshAt: time.Now().Add(pullSecretMaxAge)}, err
+}
+
+func (p *PullSecretProvider) readSecret(ctx context.Context) (string, error) {
+	glog.Infof("Refreshing CRC credentials  ")
+	secret, err := p.client.CoreV1().Secrets("openshift-config").Get(ctx, "pull-secret", metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			glog.V(2).Infof("pull-secret does not exist")
+			err = fmt.Errorf("pull-secret does not exist in openshift-config namespace: %v", err)
+		} else if errors.IsForbidden(err) {
+			glog.V(2).Infof("Operator does not have permission to check pull-secret: %v", err)
+			err = fmt.Errorf("operator does not have permission to check pull-secret: %v", err)
+		} else {
+			err = fmt.Errorf("could not check pull-secret: %v", err)
+		}
+		return "", err
+	}
+	data := secret.Data[".dockerconfigjson"]
+	if len(data) == 0 {
+		return "", fmt.Errorf(".dockerconfigjson token is not found")
+	}
+	var pullSecret serializedAuthMap
+	if err := json.Unmarshal(data, &pullSecret); err != nil {
+		glog.Errorf("Unable to unmarshal cluster pull-secret: %v", err)
+		return "", fmt.Errorf("unable to unmarshal cluster pull-secret: %v", err)
+	}
+	auth, ok := pullSecret.Auths["cloud.openshift.com"]
+	if !ok {
+		return "", fmt.Errorf("cloud.openshift.com token is not found")
+	}
+	token := strings.TrimSpace(auth.Auth)
+	if strings.Contains(token, "\n") || strings.Contains(token, "\r") {
+		return "", fmt.Errorf("cluster authorization token is not valid: contains newlines")
+	}
+	if token == "" {
+		return "", fmt.Errorf("cloud.openshift.com token is empty")
+	}
+	glog.V(2).Info("Found cloud.openshift.com token ")
+	return "Bearer " + token, nil
+}
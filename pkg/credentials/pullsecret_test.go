@@ -0,0 +1,47 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPullSecret(dockerConfig string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "openshift-config"},
+		Data:       map[string][]byte{".dockerconfigjson": []byte(dockerConfig)},
+	}
+}
+
+func Test_PullSecretProvider(t *testing.T) {
+	client := fake.NewSimpleClientset(newPullSecret(`{"auths":{"cloud.openshift.com":{"auth":"first"}}}`))
+	provider := NewPullSecretProvider(client)
+	token, err := provider.Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer first", token, "Test cloud.openshift.com token used")
+
+	_, err = client.CoreV1().Secrets("openshift-config").Update(context.TODO(),
+		newPullSecret(`{"auths":{"cloud.openshift.com":{"auth":"second"}}}`), metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	token, _ = provider.Token(context.TODO())
+	assert.Equal(t, "Bearer first", token, "Test token cached")
+
+	provider.Invalidate()
+	token, _ = provider.Token(context.TODO())
+	assert.Equal(t, "Bearer second", token, "Test secret read again after Invalidate")
+}
+
+func Test_PullSecretProvider_Errors(t *testing.T) {
+	_, err := NewPullSecretProvider(fake.NewSimpleClientset()).Token(context.TODO())
+	assert.NotNil(t, err, "Test missing pull-secret")
+
+	client := fake.NewSimpleClientset(newPullSecret(`{"auths":{"quay.io":{"auth":"other"}}}`))
+	_, err = NewPullSecretProvider(client).Token(context.TODO())
+	assert.NotNil(t, err, "Test missing cloud.openshift.com token")
+}
@@ -0,0 +1,65 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TokenKey - key of a token in the same format as CCX_TOKEN in a credentials secret
+const TokenKey = "token"
+
+// How long the token key of a secret is used before the secret is read again
+const secretMaxAge = 5 * time.Minute
+
+// SecretProvider returns the token key of a Secret, or exchanges its client_id and client_secret keys
+// at CCX_TOKEN_URL like OAuth2Provider. Holds the credentials of the clusters of another organization.
+type SecretProvider struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	cache     *cachedToken
+}
+
+// NewSecretProvider ...
+func NewSecretProvider(client kubernetes.Interface, namespace string, name string) *SecretProvider {
+	p := &SecretProvider{client: client, namespace: namespace, name: name}
+	p.cache = &cachedToken{source: "secret " + namespace + "/" + name, fetch: p.fetch}
+	return p
+}
+
+// Token returns the token of the secret
+func (p *SecretProvider) Token(ctx context.Context) (string, error) {
+	return p.cache.get(ctx)
+}
+
+// Invalidate reads the secret again on the next Token call
+func (p *SecretProvider) Invalidate() {
+	p.cache.invalidate()
+}
+
+func (p *SecretProvider) fetch(ctx context.Context) (fetchedToken, error) {
+	secret, err := p.client.CoreV1().Secrets(p.namespace).Get(ctx, p.name, metav1.GetOptions{})
+	if err != nil {
+		return fetchedToken{}, fmt.Errorf("could not get the credentials secret %s/%s: %v", p.namespace, p.name, err)
+	}
+	if token, ok := secret.Data[TokenKey]; ok {
+		parsed, err := parseToken(token, fmt.Sprintf("key %s of secret %s/%s", TokenKey, p.namespace, p.name))
+		return fetchedToken{value: parsed, refreshAt: time.Now().Add(secretMaxAge)}, err
+	}
+	clientID := strings.TrimSpace(string(secret.Data[OAuth2ClientIDKey]))
+	clientSecret := strings.TrimSpace(string(secret.Data[OAuth2ClientSecretKey]))
+	if clientID == "" || clientSecret == "" {
+		return fetchedToken{}, fmt.Errorf("secret %s/%s must have the %s key, or the %s and %s keys",
+			p.namespace, p.name, TokenKey, OAuth2ClientIDKey, OAuth2ClientSecretKey)
+	}
+	cfg := config.Get()
+	return exchange(ctx, clientID, clientSecret, cfg.CCXTokenURL, strings.Fields(cfg.CCXOAuthScopes))
+}
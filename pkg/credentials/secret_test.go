@@ -0,0 +1,49 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_SecretProvider(t *testing.T) {
+	clientSecret := "first"
+	ts, _ := newTokenServer(t, &clientSecret)
+	t.Setenv("CCX_TOKEN_URL", ts.URL)
+	assert.Nil(t, config.SetupConfig())
+	t.Cleanup(func() {
+		_ = config.SetupConfig()
+	})
+
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "org-a", Namespace: "open-cluster-management"},
+			Data:       map[string][]byte{TokenKey: []byte("Bearer org-a\n")},
+		},
+		newClientSecret("first"),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "open-cluster-management"},
+		},
+	)
+
+	token, err := NewSecretProvider(client, "open-cluster-management", "org-a").Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer org-a", token, "Test token key used")
+
+	token, err = NewSecretProvider(client, "open-cluster-management", "ccx-oauth").Token(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer access-1", token, "Test client credentials exchanged at CCX_TOKEN_URL")
+
+	_, err = NewSecretProvider(client, "open-cluster-management", "empty").Token(context.TODO())
+	assert.NotNil(t, err, "Test secret without credentials")
+
+	_, err = NewSecretProvider(client, "open-cluster-management", "missing").Token(context.TODO())
+	assert.NotNil(t, err, "Test missing secret")
+}
@@ -0,0 +1,150 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "insights_client"
+
+// Result label values
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Stage label values of PipelinePending
+const (
+	StageRetrieve = "retrieve" // clusters waiting for a retrieval worker
+	StageProcess  = "process"  // reports waiting for the processor
+)
+
+var (
+	// CCXRequestDuration - latency of the requests sent to the CCX server, by response code
+	CCXRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ccx_request_duration_seconds",
+		Help:      "Latency of the requests sent to the CCX server.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"code"})
+
+	// CCXRequests - number of requests sent to the CCX server, by response code
+	CCXRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ccx_requests_total",
+		Help:      "Number of requests sent to the CCX server by response code.",
+	}, []string{"code"})
+
+	// CCXRetries - number of requests sent to the CCX server again, by response code of the failed attempt
+	CCXRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ccx_request_retries_total",
+		Help:      "Number of requests retried after a failed attempt by response code of the attempt.",
+	}, []string{"code"})
+
+	// CCXCircuitState - 1 for the current state of the CCX circuit breaker, 0 for the others
+	CCXCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ccx_circuit_state",
+		Help:      "State of the CCX circuit breaker, 1 for the current state.",
+	}, []string{"state"})
+
+	// TokenRefreshes - outcome of the CRC token refreshes
+	TokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refresh_total",
+		Help:      "Number of CRC token refreshes by result.",
+	}, []string{"result"})
+
+	// PolicyReportOperations - outcome of the PolicyReport create/update/delete calls
+	PolicyReportOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policyreport_operations_total",
+		Help:      "Number of PolicyReport operations by operation and result.",
+	}, []string{"operation", "result"})
+
+	// PolicyReportsUnchanged - PolicyReport updates skipped because the results did not change
+	PolicyReportsUnchanged = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policyreport_unchanged_total",
+		Help:      "Number of PolicyReport updates skipped because the results did not change.",
+	})
+
+	// PipelinePending - items waiting for a free worker of a pipeline stage, the pipeline channels are unbuffered
+	PipelinePending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pipeline_pending",
+		Help:      "Number of items waiting for a free worker of a pipeline stage.",
+	}, []string{"stage"})
+
+	// MonitoredClusters - number of managed clusters being monitored
+	MonitoredClusters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "monitored_clusters",
+		Help:      "Number of managed clusters being monitored.",
+	})
+
+	// CCXClusters - number of managed clusters eligible for CCX reports
+	CCXClusters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ccx_eligible_clusters",
+		Help:      "Number of managed clusters eligible for CCX reports.",
+	})
+
+	// ServingCertExpiry - expiry of the certificate currently served
+	ServingCertExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "serving_cert_expiry_timestamp_seconds",
+		Help:      "Expiry of the serving certificate as a unix timestamp.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		CCXRequestDuration,
+		CCXRequests,
+		CCXRetries,
+		CCXCircuitState,
+		TokenRefreshes,
+		PolicyReportOperations,
+		PolicyReportsUnchanged,
+		PipelinePending,
+		MonitoredClusters,
+		CCXClusters,
+		ServingCertExpiry,
+	)
+	// Export both stages while nothing is pending
+	PipelinePending.WithLabelValues(StageRetrieve).Set(0)
+	PipelinePending.WithLabelValues(StageProcess).Set(0)
+}
+
+// StatusCodeLabel returns the code label value for a CCX response, "error" when no response was received
+func StatusCodeLabel(code int) string {
+	if code == 0 {
+		return ResultError
+	}
+	return strconv.Itoa(code)
+}
+
+// ResultLabel returns the result label value for the given error
+func ResultLabel(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// AddMetricsRoute registers /metrics on the router, behind the given middlewares
+func AddMetricsRoute(router *mux.Router, middlewares ...mux.MiddlewareFunc) {
+	var handler http.Handler = promhttp.Handler()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	router.Handle("/metrics", handler).Methods(http.MethodGet)
+}
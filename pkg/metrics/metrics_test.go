@@ -0,0 +1,38 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StatusCodeLabel(t *testing.T) {
+	assert.Equal(t, "200", StatusCodeLabel(200), "Test StatusCodeLabel: 200")
+	assert.Equal(t, "error", StatusCodeLabel(0), "Test StatusCodeLabel: no response")
+}
+
+func Test_ResultLabel(t *testing.T) {
+	assert.Equal(t, ResultSuccess, ResultLabel(nil), "Test ResultLabel: success")
+	assert.Equal(t, ResultError, ResultLabel(errors.New("failed")), "Test ResultLabel: error")
+}
+
+func Test_MetricsRoute(t *testing.T) {
+	CCXRequests.WithLabelValues("200").Inc()
+
+	router := mux.NewRouter()
+	AddMetricsRoute(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	assert.Equal(t, http.StatusOK, rec.Code, "Test /metrics status code")
+	assert.True(t, strings.Contains(body, `insights_client_pipeline_pending{stage="retrieve"}`), "Test pipeline pending gauge")
+	assert.True(t, strings.Contains(body, `insights_client_ccx_requests_total{code="200"}`), "Test CCX requests counter")
+}
@@ -0,0 +1,104 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+var (
+	clusterViolationsDesc = prometheus.NewDesc(
+		"insights_cluster_violations",
+		"Number of violations in the PolicyReport of a cluster.",
+		[]string{"cluster", "source", "total_risk", "category"}, nil,
+	)
+	clusterRuleViolationDesc = prometheus.NewDesc(
+		"insights_cluster_rule_violation",
+		"Set to 1 for each rule violated in the PolicyReport of a cluster.",
+		[]string{"cluster", "rule_id", "source", "total_risk", "category"}, nil,
+	)
+)
+
+// ViolationCollector publishes the current results of every PolicyReport written by the processor
+type ViolationCollector struct {
+	lock    sync.RWMutex
+	results map[string][]v1beta1.PolicyReportResult
+}
+
+// Violations is the collector registered with the default registry
+var Violations = NewViolationCollector()
+
+func init() {
+	prometheus.MustRegister(Violations)
+}
+
+// NewViolationCollector ...
+func NewViolationCollector() *ViolationCollector {
+	return &ViolationCollector{
+		results: map[string][]v1beta1.PolicyReportResult{},
+	}
+}
+
+// Set replaces the results published for the cluster, an empty list removes the cluster
+func (c *ViolationCollector) Set(cluster string, results []v1beta1.PolicyReportResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(results) == 0 {
+		delete(c.results, cluster)
+		return
+	}
+	c.results[cluster] = results
+}
+
+// Delete removes the results published for the cluster
+func (c *ViolationCollector) Delete(cluster string) {
+	c.Set(cluster, nil)
+}
+
+// Describe implements prometheus.Collector
+func (c *ViolationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clusterViolationsDesc
+	ch <- clusterRuleViolationDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *ViolationCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	type violationKey struct {
+		source    string
+		totalRisk string
+		category  string
+	}
+	for cluster, results := range c.results {
+		counts := map[violationKey]int{}
+		rules := map[string]bool{}
+		for _, result := range results {
+			key := violationKey{
+				source:    result.Source,
+				totalRisk: result.Properties["total_risk"],
+				category:  result.Category,
+			}
+			counts[key]++
+			// The same rule can be reported more than once, only publish the first one
+			if rules[result.Source+"/"+result.Policy] {
+				continue
+			}
+			rules[result.Source+"/"+result.Policy] = true
+			ch <- prometheus.MustNewConstMetric(
+				clusterRuleViolationDesc, prometheus.GaugeValue, 1,
+				cluster, result.Policy, key.source, key.totalRisk, key.category,
+			)
+		}
+		for key, count := range counts {
+			ch <- prometheus.MustNewConstMetric(
+				clusterViolationsDesc, prometheus.GaugeValue, float64(count),
+				cluster, key.source, key.totalRisk, key.category,
+			)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+func testResults() []v1beta1.PolicyReportResult {
+	return []v1beta1.PolicyReportResult{
+		{
+			Policy:     "master_defined_as_machinesets|MASTER_DEFINED_AS_MACHINESETS",
+			Category:   "incident",
+			Source:     "insights",
+			Properties: map[string]string{"total_risk": "3"},
+		},
+		{
+			Policy:     "container_max_root_partition_size|CONTAINER_ROOT_PARTITION_SIZE",
+			Category:   "incident",
+			Source:     "insights",
+			Properties: map[string]string{"total_risk": "3"},
+		},
+		{
+			Policy:     "default.policy1",
+			Category:   "CM Configuration Management",
+			Source:     "grc",
+			Properties: map[string]string{"total_risk": "4"},
+		},
+	}
+}
+
+func Test_ViolationCollector(t *testing.T) {
+	c := NewViolationCollector()
+	c.Set("managed-cluster", testResults())
+
+	expected := `
+# HELP insights_cluster_violations Number of violations in the PolicyReport of a cluster.
+# TYPE insights_cluster_violations gauge
+insights_cluster_violations{category="CM Configuration Management",cluster="managed-cluster",source="grc",total_risk="4"} 1
+insights_cluster_violations{category="incident",cluster="managed-cluster",source="insights",total_risk="3"} 2
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "insights_cluster_violations")
+	assert.Nil(t, err, "Test insights_cluster_violations")
+	assert.Equal(t, 3, testutil.CollectAndCount(c, "insights_cluster_rule_violation"), "Test insights_cluster_rule_violation")
+}
+
+func Test_ViolationCollector_Delete(t *testing.T) {
+	c := NewViolationCollector()
+	c.Set("managed-cluster", testResults())
+	c.Set("other-cluster", testResults())
+	c.Delete("managed-cluster")
+
+	assert.Equal(t, 3, testutil.CollectAndCount(c, "insights_cluster_rule_violation"), "Test deleted cluster is not published")
+
+	c.Set("other-cluster", nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(c), "Test empty results remove the cluster")
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/metrics"
 	"github.com/stolostron/insights-client/pkg/types"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -65,7 +66,11 @@ func GetClusterClaimInfo(managedCluster *clusterv1.ManagedCluster) (string, int6
 type Monitor struct {
 	ManagedClusterInfo  []types.ManagedClusterInfo
 	ClusterNeedsCCX     map[string]bool
-	ClusterPollInterval time.Duration // How often we want to update managed cluster list
+	ClusterPollInterval time.Duration                // How often we want to update managed cluster list
+	informerRunning     bool                         // Set while the ManagedCluster informer is running
+	pollIntervalChanged chan struct{}                // Notified by SetPollInterval
+	clusterLabels       map[string]map[string]string // ManagedCluster labels by cluster name
+	clusterAnnotations  map[string]map[string]string // ManagedCluster annotations by cluster name
 }
 
 var m *Monitor
@@ -78,13 +83,17 @@ func NewClusterMonitor() *Monitor {
 	m = &Monitor{
 		ManagedClusterInfo:  []types.ManagedClusterInfo{},
 		ClusterNeedsCCX:     map[string]bool{},
-		ClusterPollInterval: time.Duration(config.Cfg.PollInterval) * time.Minute,
+		ClusterPollInterval: config.Cfg.PollInterval,
+		pollIntervalChanged: make(chan struct{}, 1),
+		clusterLabels:       map[string]map[string]string{},
+		clusterAnnotations:  map[string]map[string]string{},
 	}
 	return m
 }
 
 // WatchClusters - Watches ManagedCluster objects and updates clusterID list for Insights call.
-func (m *Monitor) WatchClusters() {
+// The informer is stopped when the context is cancelled.
+func (m *Monitor) WatchClusters(ctx context.Context) {
 	glog.Info("Begin ClusterWatch routine")
 
 	dynamicClient := config.GetDynamicClient()
@@ -116,15 +125,15 @@ func (m *Monitor) WatchClusters() {
 	}
 
 	// Periodically check if the ManagedCluster resource exists
-	go m.stopAndStartInformer("cluster.open-cluster-management.io/v1", managedClusterInformer)
+	go m.stopAndStartInformer(ctx, "cluster.open-cluster-management.io/v1", managedClusterInformer)
 }
 
 // Stop and Start informer according to Rediscover Rate
-func (m *Monitor) stopAndStartInformer(groupVersion string, informer cache.SharedIndexInformer) {
+func (m *Monitor) stopAndStartInformer(ctx context.Context, groupVersion string, informer cache.SharedIndexInformer) {
 	var stopper chan struct{}
-	informerRunning := false
 
 	for {
+		informerRunning := m.IsInformerRunning()
 		_, err := config.GetKubeClient().ServerResourcesForGroupVersion(groupVersion)
 		// we fail to fetch for some reason other than not found
 		if err != nil && !isClusterMissing(err) {
@@ -132,17 +141,74 @@ func (m *Monitor) stopAndStartInformer(groupVersion string, informer cache.Share
 		} else {
 			if informerRunning && isClusterMissing(err) {
 				glog.Infof("Stopping cluster informer routine because %s resource not found.", groupVersion)
-				stopper <- struct{}{}
-				informerRunning = false
+				close(stopper)
+				m.setInformerRunning(false)
 			} else if !informerRunning && !isClusterMissing(err) {
 				glog.Infof("Starting cluster informer routine for cluster watch for %s resource", groupVersion)
 				stopper = make(chan struct{})
-				informerRunning = true
+				m.setInformerRunning(true)
 				go informer.Run(stopper)
 			}
 		}
-		time.Sleep(time.Duration(m.ClusterPollInterval))
+		select {
+		case <-ctx.Done():
+			if m.IsInformerRunning() {
+				glog.Info("Stopping cluster informer routine")
+				close(stopper)
+				m.setInformerRunning(false)
+			}
+			return
+		case <-time.After(m.GetPollInterval()):
+		}
+	}
+}
+
+// GetPollInterval returns how often the managed cluster reports are fetched
+func (m *Monitor) GetPollInterval() time.Duration {
+	lock.RLock()
+	defer lock.RUnlock()
+	return m.ClusterPollInterval
+}
+
+// SetPollInterval changes how often the managed cluster reports are fetched
+func (m *Monitor) SetPollInterval(interval time.Duration) {
+	lock.Lock()
+	m.ClusterPollInterval = interval
+	lock.Unlock()
+	select {
+	case m.pollIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// PollIntervalChanged is notified when SetPollInterval is called
+func (m *Monitor) PollIntervalChanged() <-chan struct{} {
+	return m.pollIntervalChanged
+}
+
+// IsInformerRunning returns true while the ManagedCluster informer is running
+func (m *Monitor) IsInformerRunning() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return m.informerRunning
+}
+
+func (m *Monitor) setInformerRunning(running bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	m.informerRunning = running
+}
+
+// updateClusterGauges exports the number of monitored and CCX eligible clusters, must be called with lock held
+func (m *Monitor) updateClusterGauges() {
+	ccxClusters := 0
+	for _, needsCCX := range m.ClusterNeedsCCX {
+		if needsCCX {
+			ccxClusters++
+		}
 	}
+	metrics.MonitoredClusters.Set(float64(len(m.ManagedClusterInfo)))
+	metrics.CCXClusters.Set(float64(ccxClusters))
 }
 
 var mux sync.Mutex
@@ -169,6 +235,8 @@ func (m *Monitor) processCluster(obj interface{}, handlerType string) {
 		glog.Warning("Failed to Unmarshal ManagedCluster", err)
 	}
 
+	m.setClusterMetadata(&managedCluster, handlerType == "delete")
+
 	switch handlerType {
 	case "add":
 		m.addCluster(&managedCluster)
@@ -181,6 +249,40 @@ func (m *Monitor) processCluster(obj interface{}, handlerType string) {
 	}
 }
 
+// setClusterMetadata keeps the labels and annotations of the ManagedCluster for the InsightsClientConfig
+// cluster selector and the cluster credentials
+func (m *Monitor) setClusterMetadata(managedCluster *clusterv1.ManagedCluster, deleted bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	if m.clusterLabels == nil {
+		m.clusterLabels = map[string]map[string]string{}
+	}
+	if m.clusterAnnotations == nil {
+		m.clusterAnnotations = map[string]map[string]string{}
+	}
+	if deleted {
+		delete(m.clusterLabels, managedCluster.GetName())
+		delete(m.clusterAnnotations, managedCluster.GetName())
+		return
+	}
+	m.clusterLabels[managedCluster.GetName()] = managedCluster.GetLabels()
+	m.clusterAnnotations[managedCluster.GetName()] = managedCluster.GetAnnotations()
+}
+
+// GetClusterLabels returns the labels of the ManagedCluster
+func (m *Monitor) GetClusterLabels(name string) map[string]string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return m.clusterLabels[name]
+}
+
+// GetClusterAnnotations returns the annotations of the ManagedCluster
+func (m *Monitor) GetClusterAnnotations(name string) map[string]string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return m.clusterAnnotations[name]
+}
+
 func (m *Monitor) addCluster(managedCluster *clusterv1.ManagedCluster) {
 	glog.V(2).Info("Processing Cluster Addition.")
 	glog.V(2).Infof("Currently mangaging %d clusters.", len(m.ManagedClusterInfo))
@@ -198,6 +300,7 @@ func (m *Monitor) addCluster(managedCluster *clusterv1.ManagedCluster) {
 	}
 	lock.Lock()
 	defer lock.Unlock()
+	defer m.updateClusterGauges()
 
 	_, found := Find(m.ManagedClusterInfo, types.ManagedClusterInfo{
 		Namespace: managedCluster.GetName(),
@@ -228,6 +331,7 @@ func (m *Monitor) updateCluster(managedCluster *clusterv1.ManagedCluster) {
 	glog.V(2).Infof("Currently mangaging %d clusters.", len(m.ManagedClusterInfo))
 	lock.Lock()
 	defer lock.Unlock()
+	defer m.updateClusterGauges()
 	clusterToUpdate := managedCluster.GetName()
 	if clusterToUpdate == localClusterName {
 		// We get local-clsuter ID from clusterversion resource.
@@ -277,10 +381,12 @@ func (m *Monitor) deleteCluster(managedCluster *clusterv1.ManagedCluster) {
 	glog.V(2).Infof("Currently mangaging %d clusters.", len(m.ManagedClusterInfo))
 	lock.Lock()
 	defer lock.Unlock()
+	defer m.updateClusterGauges()
 	clusterToDelete := managedCluster.GetName()
 	for clusterIdx, cluster := range m.ManagedClusterInfo {
 		if clusterToDelete == cluster.Namespace && clusterToDelete != localClusterName {
 			glog.Infof("Removing %s from Insights cluster list", clusterToDelete)
+			metrics.Violations.Delete(clusterToDelete)
 			delete(m.ClusterNeedsCCX, m.ManagedClusterInfo[clusterIdx].ClusterID)
 			m.ManagedClusterInfo = append(m.ManagedClusterInfo[:clusterIdx], m.ManagedClusterInfo[clusterIdx+1:]...)
 		}
@@ -289,7 +395,7 @@ func (m *Monitor) deleteCluster(managedCluster *clusterv1.ManagedCluster) {
 }
 
 // AddLocalCluster - adds local cluster to Clusters list
-func (m *Monitor) AddLocalCluster(versionObj *unstructured.Unstructured) bool {
+func (m *Monitor) AddLocalCluster(ctx context.Context, versionObj *unstructured.Unstructured) bool {
 	var clusterVersionGvr = schema.GroupVersionResource{
 		Group:    "config.openshift.io",
 		Version:  "v1",
@@ -300,7 +406,7 @@ func (m *Monitor) AddLocalCluster(versionObj *unstructured.Unstructured) bool {
 	glog.V(2).Info("Adding Local Cluster ID.")
 	if versionObj == nil {
 		dynamicClient = config.GetDynamicClient()
-		versionObj, err = dynamicClient.Resource(clusterVersionGvr).Get(context.TODO(), "version", metav1.GetOptions{})
+		versionObj, err = dynamicClient.Resource(clusterVersionGvr).Get(ctx, "version", metav1.GetOptions{})
 	}
 	if err != nil {
 		glog.V(2).Infof("Failed to get clusterversions : %v", err)
@@ -315,6 +421,7 @@ func (m *Monitor) AddLocalCluster(versionObj *unstructured.Unstructured) bool {
 	if clusterID != "" {
 		lock.Lock()
 		defer lock.Unlock()
+		defer m.updateClusterGauges()
 		m.ManagedClusterInfo = append(m.ManagedClusterInfo, types.ManagedClusterInfo{
 			ClusterID: clusterID,
 			Namespace: localClusterName,
@@ -329,6 +436,8 @@ func (m *Monitor) AddLocalCluster(versionObj *unstructured.Unstructured) bool {
 // GetLocalCluster - GET ID from Clusters list
 func (m *Monitor) GetLocalCluster() string {
 	glog.V(2).Info("Getting local-cluster id .")
+	lock.RLock()
+	defer lock.RUnlock()
 	for _, cluster := range m.ManagedClusterInfo {
 		if localClusterName == cluster.Namespace {
 			return cluster.ClusterID
@@ -342,5 +451,15 @@ func (m *Monitor) GetManagedClusterInfo() []types.ManagedClusterInfo {
 	lock.Lock()
 	defer lock.Unlock()
 	glog.V(2).Infof("Total managed clusters in processing list  %d .", len(m.ManagedClusterInfo))
-	return m.ManagedClusterInfo
+	// Return a copy, the list is modified in place when clusters are removed
+	clusters := make([]types.ManagedClusterInfo, len(m.ManagedClusterInfo))
+	copy(clusters, m.ManagedClusterInfo)
+	return clusters
+}
+
+// NeedsCCX returns true if Insights reports are retrieved for the cluster with the given ID
+func (m *Monitor) NeedsCCX(clusterID string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return m.ClusterNeedsCCX[clusterID]
 }
@@ -3,10 +3,12 @@
 package monitor
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	sanitize "github.com/kennygrant/sanitize"
 	"github.com/stolostron/insights-client/pkg/types"
@@ -137,7 +139,7 @@ func Test_AddLocalCluster(t *testing.T) {
 			},
 		},
 	}
-	monitor.AddLocalCluster(versionU)
+	monitor.AddLocalCluster(context.TODO(), versionU)
 	assert.Equal(t, types.ManagedClusterInfo{Namespace: "local-cluster", ClusterID: "58bd7441-812e-4fab-9aa6-eec452059c59"}, monitor.ManagedClusterInfo[0], "Test AddLocalCluster: local-cluster")
 
 }
@@ -159,7 +161,37 @@ func Test_GetLocalCluster(t *testing.T) {
 			},
 		},
 	}
-	monitor.AddLocalCluster(versionU)
+	monitor.AddLocalCluster(context.TODO(), versionU)
 	assert.Equal(t, "58bd7441-812e-4fab-9aa6-eec452059c59", monitor.GetLocalCluster(), "Test GetLocalCluster: local-cluster")
 
 }
+
+func Test_SetPollInterval(t *testing.T) {
+	monitor := NewClusterMonitor()
+	previous := monitor.GetPollInterval()
+	defer monitor.SetPollInterval(previous)
+
+	monitor.SetPollInterval(5 * time.Minute)
+	assert.Equal(t, 5*time.Minute, monitor.GetPollInterval(), "Test SetPollInterval: interval updated")
+	select {
+	case <-monitor.PollIntervalChanged():
+	default:
+		t.Error("Expected the poll interval change to be notified")
+	}
+}
+
+func Test_setClusterMetadata(t *testing.T) {
+	monitor := NewClusterMonitor()
+	managedCluster := clusterv1.ManagedCluster{}
+	unmarshalFile("managed-cluster.json", &managedCluster, t)
+	managedCluster.SetAnnotations(map[string]string{"insights.open-cluster-management.io/ccx-token-secret": "org-b"})
+
+	monitor.setClusterMetadata(&managedCluster, false)
+	assert.Equal(t, "Amazon", monitor.GetClusterLabels("managed-cluster")["cloud"], "Test ManagedCluster labels kept")
+	assert.Equal(t, "org-b", monitor.GetClusterAnnotations("managed-cluster")["insights.open-cluster-management.io/ccx-token-secret"],
+		"Test ManagedCluster annotations kept")
+
+	monitor.setClusterMetadata(&managedCluster, true)
+	assert.Nil(t, monitor.GetClusterLabels("managed-cluster"), "Test ManagedCluster labels removed on delete")
+	assert.Nil(t, monitor.GetClusterAnnotations("managed-cluster"), "Test ManagedCluster annotations removed on delete")
+}
@@ -11,20 +11,44 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/clientconfig"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"github.com/stolostron/insights-client/pkg/store"
 	"github.com/stolostron/insights-client/pkg/types"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	ktypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
 )
 
 var prSuffix = "-policyreport"
 
+// Annotations describing the Insights results of the PolicyReport
+const (
+	// ReportTimeAnnotation - time the report with the Insights results was received from CCX, RFC3339
+	ReportTimeAnnotation = "insights.open-cluster-management.io/report-time"
+	// DataAgeAnnotation - age of the stale Insights report when the PolicyReport was written, e.g. 2h30m0s
+	DataAgeAnnotation = "insights.open-cluster-management.io/data-age"
+	// StaleAnnotation - "true" when the report could not be fetched and the last results received are kept
+	StaleAnnotation = "insights.open-cluster-management.io/stale"
+)
+
+// insightsState describes the Insights results written to the PolicyReport
+type insightsState struct {
+	reportTime time.Time // time the report was received from CCX, zero when unknown
+	stale      bool      // the last fetch failed
+}
+
 // Processor struct
 type Processor struct {
+	Store        *store.ReportStore       // results written by the processor and on-demand refreshes
+	ClientConfig *clientconfig.Controller // rule filters and sinks from the InsightsClientConfig
 }
 
 var policyReportGvr = schema.GroupVersionResource{
@@ -41,7 +65,10 @@ var policyGvr = schema.GroupVersionResource{
 
 // NewProcessor ...
 func NewProcessor() *Processor {
-	p := &Processor{}
+	p := &Processor{
+		Store:        store.NewReportStore(),
+		ClientConfig: clientconfig.NewController(),
+	}
 	return p
 }
 
@@ -87,17 +114,20 @@ func getPolicyReportResults(
 }
 
 // CreateUpdatePolicyReports - Creates a PolicyReport for cluster if one does not already exist and updates the status of violations
-func (p *Processor) createUpdatePolicyReports(input chan types.ProcessorData, dynamicClient dynamic.Interface) {
-	data := <-input
-
+func (p *Processor) createUpdatePolicyReports(
+	ctx context.Context,
+	data types.ProcessorData,
+	dynamicClient dynamic.Interface,
+) {
 	if data.ClusterInfo.ClusterID == "" || data.ClusterInfo.Namespace == "" {
 		glog.Info("Missing managed cluster ID and/or Namespace nothing to process")
 		return
 	}
+	defer p.Store.CompleteRefresh(data.ClusterInfo.Namespace, data.RetrievedAt)
 
 	currentPolicyReport := v1beta1.PolicyReport{}
 	policyReportRes, _ := dynamicClient.Resource(policyReportGvr).Namespace(data.ClusterInfo.Namespace).Get(
-		context.TODO(),
+		ctx,
 		data.ClusterInfo.Namespace+prSuffix,
 		metav1.GetOptions{},
 	)
@@ -113,25 +143,57 @@ func (p *Processor) createUpdatePolicyReports(input chan types.ProcessorData, dy
 		}
 	}
 
-	clusterViolations := getPolicyReportResults(
-		data.Report.Data,
-		data.ClusterInfo,
-	)
+	if data.Deselected {
+		p.removeResults(ctx, data.ClusterInfo, &currentPolicyReport, dynamicClient)
+		return
+	}
 
-	govViolations := getGovernanceResults(dynamicClient, data.ClusterInfo)
+	clusterViolations, insights := p.insightsResults(data, &currentPolicyReport)
+
+	govViolations := getGovernanceResults(ctx, dynamicClient, data.ClusterInfo)
 	if len(govViolations) > 0 {
 		clusterViolations = append(clusterViolations, govViolations...)
 	}
 
+	clusterViolations = p.ClientConfig.FilterResults(clusterViolations)
+	if p.ClientConfig.PublishMetrics() {
+		metrics.Violations.Set(data.ClusterInfo.Namespace, clusterViolations)
+	} else {
+		metrics.Violations.Delete(data.ClusterInfo.Namespace)
+	}
+	p.Store.SetResults(data.ClusterInfo, clusterViolations)
+	if !p.ClientConfig.WritePolicyReports() {
+		glog.V(2).Infof("PolicyReport sink disabled, skipping PolicyReport for cluster %s", data.ClusterInfo.Namespace)
+		if currentPolicyReport.GetName() != "" {
+			// Written before the sink was disabled, its results are no longer updated
+			deletePolicyReport(ctx, data.ClusterInfo, dynamicClient)
+		}
+		return
+	}
+
+	fingerprint := store.NewReportFingerprint(data.Report)
 	if currentPolicyReport.GetName() == "" && len(clusterViolations) > 0 {
 		// If PolicyReport does not exist for cluster -> create it ONLY if there are violations
-		createPolicyReport(clusterViolations, data.ClusterInfo, dynamicClient)
+		err := createPolicyReport(ctx, clusterViolations, insights, data.ClusterInfo, dynamicClient)
+		p.setProcessed(data, fingerprint, err)
 	} else if currentPolicyReport.GetName() != "" && len(clusterViolations) > 0 {
+		if p.unchanged(data, fingerprint, &currentPolicyReport, clusterViolations) {
+			glog.V(2).Infof(
+				"Results of cluster %s (%s) did not change, skipping the PolicyReport update",
+				data.ClusterInfo.Namespace,
+				data.ClusterInfo.ClusterID,
+			)
+			metrics.PolicyReportsUnchanged.Inc()
+			patchReportTime(ctx, &currentPolicyReport, insights.reportTime, data.ClusterInfo, dynamicClient)
+			return
+		}
 		// If PolicyReport exists -> add new violations and remove violations no longer present
-		updatePolicyReportViolations(&currentPolicyReport, clusterViolations, data.ClusterInfo, dynamicClient)
+		err := updatePolicyReportViolations(ctx, &currentPolicyReport, clusterViolations, insights, data.ClusterInfo,
+			dynamicClient)
+		p.setProcessed(data, fingerprint, err)
 	} else if currentPolicyReport.GetName() != "" && len(clusterViolations) == 0 {
 		// If PolicyReport no longer has violations && No policyresults from grc-> delete PolicyReport for cluster
-		deletePolicyReport(data.ClusterInfo, dynamicClient)
+		deletePolicyReport(ctx, data.ClusterInfo, dynamicClient)
 
 	} else if currentPolicyReport.GetName() == "" && len(clusterViolations) == 0 {
 		glog.Infof(
@@ -142,6 +204,158 @@ func (p *Processor) createUpdatePolicyReports(input chan types.ProcessorData, dy
 	}
 }
 
+// removeResults removes the results of a cluster no longer selected by the InsightsClientConfig: its PolicyReport,
+// its violation metrics and its report in the store
+func (p *Processor) removeResults(
+	ctx context.Context,
+	clusterInfo types.ManagedClusterInfo,
+	currentPolicyReport *v1beta1.PolicyReport,
+	dynamicClient dynamic.Interface,
+) {
+	glog.Infof(
+		"Cluster %s (%s) is not selected by the InsightsClientConfig, removing its results",
+		clusterInfo.Namespace,
+		clusterInfo.ClusterID,
+	)
+	metrics.Violations.Delete(clusterInfo.Namespace)
+	p.Store.Delete(clusterInfo.Namespace)
+	if currentPolicyReport.GetName() != "" {
+		deletePolicyReport(ctx, clusterInfo, dynamicClient)
+	}
+}
+
+// unchanged returns true when the PolicyReport already has the results: the report has the fingerprint of the
+// last one written, and the results only differ by their timestamps
+func (p *Processor) unchanged(
+	data types.ProcessorData,
+	fingerprint store.ReportFingerprint,
+	currentPolicyReport *v1beta1.PolicyReport,
+	clusterViolations []v1beta1.PolicyReportResult,
+) bool {
+	if data.Stale || currentPolicyReport.GetAnnotations()[StaleAnnotation] != "" || currentPolicyReport.Source == "" {
+		return false
+	}
+	entry, found := p.Store.Get(data.ClusterInfo.Namespace)
+	if !found || entry.Processed == nil || !entry.Processed.Equal(fingerprint) {
+		return false
+	}
+	if len(currentPolicyReport.Results) != len(clusterViolations) {
+		return false
+	}
+	for i := range clusterViolations {
+		current, result := currentPolicyReport.Results[i], clusterViolations[i]
+		current.Timestamp, result.Timestamp = metav1.Timestamp{}, metav1.Timestamp{}
+		if !equality.Semantic.DeepEqual(current, result) {
+			return false
+		}
+	}
+	return true
+}
+
+// setProcessed records the fingerprint of the report once its results are written to the PolicyReport
+func (p *Processor) setProcessed(data types.ProcessorData, fingerprint store.ReportFingerprint, err error) {
+	if err == nil && !data.Stale {
+		p.Store.SetProcessed(data.ClusterInfo, fingerprint)
+	}
+}
+
+// insightsResults returns the results of the report. When the fetch failed, the results of the last report
+// received are kept until REPORT_EXPIRY. They are read from the PolicyReport when that report was received
+// before the client started, and kept with an unknown age when the PolicyReport has no time for them.
+func (p *Processor) insightsResults(
+	data types.ProcessorData,
+	currentPolicyReport *v1beta1.PolicyReport,
+) ([]v1beta1.PolicyReportResult, insightsState) {
+	insights := insightsState{reportTime: data.ReportTime, stale: data.Stale}
+	if !data.Stale {
+		return getPolicyReportResults(data.Report.Data, data.ClusterInfo), insights
+	}
+	var results []v1beta1.PolicyReportResult
+	if data.ReportTime.IsZero() {
+		results, insights.reportTime = lastInsightsResults(currentPolicyReport)
+	} else {
+		results = getPolicyReportResults(data.Report.Data, data.ClusterInfo)
+	}
+	if len(results) == 0 {
+		return nil, insightsState{}
+	}
+	if insights.reportTime.IsZero() {
+		glog.Infof(
+			"Keeping the Insights results of cluster %s (%s) of unknown age until the report can be fetched again",
+			data.ClusterInfo.Namespace,
+			data.ClusterInfo.ClusterID,
+		)
+		return results, insights
+	}
+	age := time.Since(insights.reportTime).Round(time.Second)
+	if age >= config.Get().ReportExpiry {
+		glog.Warningf(
+			"The last Insights report of cluster %s (%s) is %s old, dropping its results older than REPORT_EXPIRY",
+			data.ClusterInfo.Namespace,
+			data.ClusterInfo.ClusterID,
+			age,
+		)
+		p.Store.DropReport(data.ClusterInfo.Namespace)
+		return nil, insightsState{}
+	}
+	glog.Infof(
+		"Keeping the Insights results of cluster %s (%s) received %s ago until the report can be fetched again",
+		data.ClusterInfo.Namespace,
+		data.ClusterInfo.ClusterID,
+		age,
+	)
+	return results, insights
+}
+
+// lastInsightsResults returns the Insights results of the PolicyReport and the time their report was received.
+// Without the report time annotation, e.g. written by a previous version, the time the results were last written
+// or else the creation time of the PolicyReport is used. The time is zero when none is known.
+func lastInsightsResults(policyReport *v1beta1.PolicyReport) ([]v1beta1.PolicyReportResult, time.Time) {
+	var results []v1beta1.PolicyReportResult
+	var writtenAt time.Time
+	for _, result := range policyReport.Results {
+		if result.Source == "insights" {
+			results = append(results, result)
+			if timestamp := time.Unix(result.Timestamp.Seconds, 0); result.Timestamp.Seconds > 0 &&
+				timestamp.After(writtenAt) {
+				writtenAt = timestamp
+			}
+		}
+	}
+	if reportTime, err := time.Parse(time.RFC3339, policyReport.GetAnnotations()[ReportTimeAnnotation]); err == nil {
+		return results, reportTime
+	}
+	if writtenAt.IsZero() {
+		writtenAt = policyReport.GetCreationTimestamp().Time
+	}
+	return results, writtenAt
+}
+
+// setInsightsAnnotations sets the report time annotation of the PolicyReport, and the data age and stale
+// annotations while the results are stale. They are removed when the results come from no report.
+func setInsightsAnnotations(policyReport *v1beta1.PolicyReport, insights insightsState) {
+	annotations := policyReport.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, ReportTimeAnnotation)
+	delete(annotations, DataAgeAnnotation)
+	delete(annotations, StaleAnnotation)
+	if insights.stale {
+		annotations[StaleAnnotation] = "true"
+	}
+	if !insights.reportTime.IsZero() {
+		annotations[ReportTimeAnnotation] = insights.reportTime.UTC().Format(time.RFC3339)
+		if insights.stale {
+			annotations[DataAgeAnnotation] = time.Since(insights.reportTime).Round(time.Second).String()
+		}
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	policyReport.SetAnnotations(annotations)
+}
+
 func convertSevFromGovernance(policySev string) string {
 	policySev = strings.ToLower(policySev)
 	sevMapping := map[string]interface{}{
@@ -157,7 +371,11 @@ func convertSevFromGovernance(policySev string) string {
 }
 
 // getGovernanceResults creates a result object for each policy violation in the cluster
-func getGovernanceResults(dynamicClient dynamic.Interface, clusterInfo types.ManagedClusterInfo) []v1beta1.PolicyReportResult {
+func getGovernanceResults(
+	ctx context.Context,
+	dynamicClient dynamic.Interface,
+	clusterInfo types.ManagedClusterInfo,
+) []v1beta1.PolicyReportResult {
 	glog.V(1).Infof(
 		"Getting policy violations for cluster %s (%s)",
 		clusterInfo.Namespace,
@@ -165,7 +383,7 @@ func getGovernanceResults(dynamicClient dynamic.Interface, clusterInfo types.Man
 	)
 
 	res := dynamicClient.Resource(policyGvr).Namespace(clusterInfo.Namespace)
-	policyList, err := res.List(context.TODO(), metav1.ListOptions{})
+	policyList, err := res.List(ctx, metav1.ListOptions{})
 	if err != nil {
 		glog.Warningf(
 			"Error getting policy data for cluster %s (%s)",
@@ -350,8 +568,10 @@ func getSevFromTemplate(plc unstructured.Unstructured, name string, statusIndex
 }
 
 func createPolicyReport(
+	ctx context.Context,
 	clusterViolations []v1beta1.PolicyReportResult,
-	clusterInfo types.ManagedClusterInfo, dynamicClient dynamic.Interface) {
+	insights insightsState,
+	clusterInfo types.ManagedClusterInfo, dynamicClient dynamic.Interface) error {
 	glog.V(1).Infof(
 		"Starting createPolicyReport for cluster %s (%s)",
 		clusterInfo.Namespace,
@@ -381,6 +601,7 @@ func createPolicyReport(
 			Skip:  0,
 		},
 	}
+	setInsightsAnnotations(policyreport, insights)
 	prUnstructured, unstructuredErr := runtime.DefaultUnstructuredConverter.ToUnstructured(policyreport)
 	if unstructuredErr != nil {
 		glog.Warningf("Error converting to unstructured.Unstructured: %s", unstructuredErr)
@@ -388,10 +609,11 @@ func createPolicyReport(
 	obj := &unstructured.Unstructured{Object: prUnstructured}
 
 	_, err := dynamicClient.Resource(policyReportGvr).Namespace(clusterInfo.Namespace).Create(
-		context.TODO(),
+		ctx,
 		obj,
 		metav1.CreateOptions{},
 	)
+	metrics.PolicyReportOperations.WithLabelValues("create", metrics.ResultLabel(err)).Inc()
 
 	if err != nil {
 		glog.Warningf(
@@ -407,12 +629,15 @@ func createPolicyReport(
 			clusterInfo.ClusterID,
 		)
 	}
+	return err
 }
 
 func updatePolicyReportViolations(
+	ctx context.Context,
 	currentPolicyReport *v1beta1.PolicyReport,
 	clusterViolations []v1beta1.PolicyReportResult,
-	clusterInfo types.ManagedClusterInfo, dynamicClient dynamic.Interface) {
+	insights insightsState,
+	clusterInfo types.ManagedClusterInfo, dynamicClient dynamic.Interface) error {
 	glog.V(2).Infof(
 		"Starting updatePolicyReportViolations for cluster %s (%s)",
 		clusterInfo.Namespace,
@@ -422,6 +647,7 @@ func updatePolicyReportViolations(
 	currentPolicyReport.Results = clusterViolations
 	currentPolicyReport.SetManagedFields(nil)
 	currentPolicyReport.Summary.Fail = len(clusterViolations)
+	setInsightsAnnotations(currentPolicyReport, insights)
 
 	if currentPolicyReport.Source == "" {
 		currentPolicyReport.Source = clusterInfo.ClusterID
@@ -433,10 +659,11 @@ func updatePolicyReportViolations(
 	}
 	obj := &unstructured.Unstructured{Object: prUnstructured}
 	successUpdateRes, err := dynamicClient.Resource(policyReportGvr).Namespace(clusterInfo.Namespace).Update(
-		context.TODO(),
+		ctx,
 		obj,
 		metav1.UpdateOptions{},
 	)
+	metrics.PolicyReportOperations.WithLabelValues("update", metrics.ResultLabel(err)).Inc()
 
 	if successUpdateRes != nil && err == nil {
 		unstructConvErr := runtime.DefaultUnstructuredConverter.FromUnstructured(
@@ -462,19 +689,57 @@ func updatePolicyReportViolations(
 			clusterInfo.ClusterID,
 		)
 	}
+	return err
+}
+
+// patchReportTime only sets the report time annotation of the PolicyReport whose results did not change,
+// so the results are kept until REPORT_EXPIRY after the last time they were received
+func patchReportTime(
+	ctx context.Context,
+	policyReport *v1beta1.PolicyReport,
+	reportTime time.Time,
+	clusterInfo types.ManagedClusterInfo,
+	dynamicClient dynamic.Interface,
+) {
+	value := reportTime.UTC().Format(time.RFC3339)
+	if reportTime.IsZero() || policyReport.GetAnnotations()[ReportTimeAnnotation] == value {
+		return
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{ReportTimeAnnotation: value},
+		},
+	})
+	_, err := dynamicClient.Resource(policyReportGvr).Namespace(clusterInfo.Namespace).Patch(
+		ctx,
+		policyReport.GetName(),
+		ktypes.MergePatchType,
+		patch,
+		metav1.PatchOptions{},
+	)
+	metrics.PolicyReportOperations.WithLabelValues("patch", metrics.ResultLabel(err)).Inc()
+	if err != nil {
+		glog.Warningf(
+			"Error setting the report time of the PolicyReport for cluster %s (%s): %v",
+			clusterInfo.Namespace,
+			clusterInfo.ClusterID,
+			err,
+		)
+	}
 }
 
-func deletePolicyReport(clusterInfo types.ManagedClusterInfo, dynamicClient dynamic.Interface) {
+func deletePolicyReport(ctx context.Context, clusterInfo types.ManagedClusterInfo, dynamicClient dynamic.Interface) {
 	glog.V(2).Infof(
 		"Starting deletePolicyReport for cluster %s (%s)",
 		clusterInfo.Namespace,
 		clusterInfo.ClusterID,
 	)
 	deleteErr := dynamicClient.Resource(policyReportGvr).Namespace(clusterInfo.Namespace).Delete(
-		context.TODO(),
+		ctx,
 		clusterInfo.Namespace+prSuffix,
 		metav1.DeleteOptions{},
 	)
+	metrics.PolicyReportOperations.WithLabelValues("delete", metrics.ResultLabel(deleteErr)).Inc()
 
 	if deleteErr != nil {
 		glog.Warningf("Error deleting PolicyReport for cluster %s (%s): %v",
@@ -490,9 +755,20 @@ func deletePolicyReport(clusterInfo types.ManagedClusterInfo, dynamicClient dyna
 	}
 }
 
-// ProcessPolicyReports ...
-func (p *Processor) ProcessPolicyReports(input chan types.ProcessorData, dynamicClient dynamic.Interface) {
+// ProcessPolicyReports ... Returns when the context is cancelled. The PolicyReport being written
+// at that time is not interrupted so a cluster is never left with a half-written report.
+func (p *Processor) ProcessPolicyReports(
+	ctx context.Context,
+	input chan types.ProcessorData,
+	dynamicClient dynamic.Interface,
+) {
 	for {
-		p.createUpdatePolicyReports(input, dynamicClient)
+		select {
+		case <-ctx.Done():
+			glog.Info("Stopping PolicyReport processing")
+			return
+		case data := <-input:
+			p.createUpdatePolicyReports(context.WithoutCancel(ctx), data, dynamicClient)
+		}
 	}
 }
@@ -8,17 +8,27 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/kennygrant/sanitize"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/insights-client/pkg/clientconfig"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/metrics"
 	"github.com/stolostron/insights-client/pkg/retriever"
+	"github.com/stolostron/insights-client/pkg/store"
 	"github.com/stolostron/insights-client/pkg/types"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
 	dynamicfakeclient "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
 	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
 )
 
@@ -48,7 +58,7 @@ var (
 func setUp(t *testing.T) {
 	fetchPolicyReports = make(chan types.ProcessorData, 1)
 
-	ret = retriever.NewRetriever("testReportUrl", nil, "testToken")
+	ret = retriever.NewRetriever("testReportUrl", nil, credentials.NewStaticProvider("testToken"))
 
 	mngd = types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "972ea7cf-7428-438f-ade8-12ac4794ede0"}
 
@@ -254,7 +264,7 @@ func Test_createPolicyReport(t *testing.T) {
 	setUp(t)
 	addReportToChannel(t, "createreporttest.json")
 
-	processor.createUpdatePolicyReports(fetchPolicyReports, fakeDynamicClient)
+	processor.createUpdatePolicyReports(context.TODO(), <-fetchPolicyReports, fakeDynamicClient)
 	createdPolicyReport := &v1beta1.PolicyReport{}
 
 	// Check if the policyReport is created
@@ -321,6 +331,273 @@ func Test_createPolicyReport(t *testing.T) {
 	assert.True(t, foundContainerPartition, "Expected to find container partition insights result")
 	assert.True(t, foundNamespaceViolation, "Expected to find namespace violation insights result")
 	assert.True(t, foundGatekeeperViolation, "Expected to find Gatekeeper violation insights result")
+
+	// The violations written to the PolicyReport are published as metrics
+	assert.Equal(t, 4, testutil.CollectAndCount(metrics.Violations, "insights_cluster_rule_violation"),
+		"Expected a rule violation metric for each distinct source and policy")
+}
+
+func Test_createUpdatePolicyReports_CompletesRefresh(t *testing.T) {
+	setUp(t)
+	refresh := processor.Store.StartRefresh([]string{mngd.Namespace})
+	fetchPolicyReports <- types.ProcessorData{ClusterInfo: mngd, RetrievedAt: time.Now()}
+
+	processor.createUpdatePolicyReports(context.TODO(), <-fetchPolicyReports, fakeDynamicClient)
+
+	refresh, _ = processor.Store.GetRefresh(refresh.ID)
+	assert.Equal(t, store.RefreshCompleted, refresh.Status, "Expected the refresh to be completed")
+}
+
+// The results of a cluster no longer selected by the InsightsClientConfig are removed
+func Test_createUpdatePolicyReports_Deselected(t *testing.T) {
+	setUp(t)
+	UnmarshalFile("createreporttest.json", &respBody, t)
+	data, _ := ret.GetPolicyInfo(respBody, mngd)
+	processor.Store.SetReport(mngd, data.Report, store.Validators{})
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	getPolicyReport(t)
+
+	processor.createUpdatePolicyReports(context.TODO(),
+		types.ProcessorData{ClusterInfo: mngd, Deselected: true}, fakeDynamicClient)
+	_, err := fakeDynamicClient.Resource(policyReportGvr).Namespace(mngd.Namespace).Get(
+		context.TODO(), mngd.Namespace+prSuffix, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err), "Expected the PolicyReport of the deselected cluster to be deleted")
+	_, found := processor.Store.Get(mngd.Namespace)
+	assert.False(t, found, "Expected the report of the deselected cluster to be removed from the store")
+}
+
+// The PolicyReports written before the sink was disabled are deleted
+func Test_createUpdatePolicyReports_SinkDisabled(t *testing.T) {
+	setUp(t)
+	UnmarshalFile("createreporttest.json", &respBody, t)
+	data, _ := ret.GetPolicyInfo(respBody, mngd)
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	getPolicyReport(t)
+
+	processor.ClientConfig = newClientConfig(t, clientconfig.InsightsClientConfigSpec{
+		Sinks: clientconfig.Sinks{PolicyReports: new(bool)},
+	})
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	_, err := fakeDynamicClient.Resource(policyReportGvr).Namespace(mngd.Namespace).Get(
+		context.TODO(), mngd.Namespace+prSuffix, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err), "Expected the PolicyReport to be deleted when the sink is disabled")
+}
+
+// newClientConfig returns a controller once it applied an InsightsClientConfig with the spec
+func newClientConfig(t *testing.T, spec clientconfig.InsightsClientConfigSpec) *clientconfig.Controller {
+	t.Cleanup(func() { _ = config.SetResourceOverrides(config.Config{}) })
+	resource := &clientconfig.InsightsClientConfig{
+		TypeMeta:   metav1.TypeMeta{APIVersion: clientconfig.GroupVersion.String(), Kind: "InsightsClientConfig"},
+		ObjectMeta: metav1.ObjectMeta{Name: clientconfig.ConfigName},
+		Spec:       spec,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	assert.Nil(t, err)
+	gvr := clientconfig.GroupVersion.WithResource("insightsclientconfigs")
+	dynamicClient := dynamicfakeclient.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "InsightsClientConfigList"},
+		&unstructured.Unstructured{Object: content},
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: clientconfig.GroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: gvr.Resource}},
+	}}
+
+	controller := clientconfig.NewController()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	controller.Watch(ctx, dynamicClient, discoveryClient)
+	assert.Eventually(t, func() bool { return !controller.WritePolicyReports() }, 5*time.Second, 10*time.Millisecond,
+		"Expected the InsightsClientConfig to be applied")
+	return controller
+}
+
+func getPolicyReport(t *testing.T) v1beta1.PolicyReport {
+	policyReport := v1beta1.PolicyReport{}
+	res, err := fakeDynamicClient.Resource(policyReportGvr).Namespace(mngd.Namespace).Get(
+		context.TODO(), mngd.Namespace+prSuffix, metav1.GetOptions{})
+	assert.Nil(t, err, "Expected the PolicyReport to exist")
+	if err == nil {
+		assert.Nil(t, runtime.DefaultUnstructuredConverter.FromUnstructured(res.UnstructuredContent(), &policyReport))
+	}
+	return policyReport
+}
+
+func countSource(policyReport v1beta1.PolicyReport, source string) int {
+	count := 0
+	for _, result := range policyReport.Results {
+		if result.Source == source {
+			count++
+		}
+	}
+	return count
+}
+
+func Test_createUpdatePolicyReports_StaleResults(t *testing.T) {
+	t.Setenv("REPORT_EXPIRY", "1h")
+	assert.Nil(t, config.SetupConfig())
+	t.Cleanup(func() { _ = config.SetupConfig() })
+	setUp(t)
+	UnmarshalFile("createreporttest.json", &respBody, t)
+	data, _ := ret.GetPolicyInfo(respBody, mngd)
+
+	reportTime := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	data.ReportTime = reportTime
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	policyReport := getPolicyReport(t)
+	assert.Equal(t, reportTime.UTC().Format(time.RFC3339), policyReport.GetAnnotations()[ReportTimeAnnotation],
+		"Expected the report time annotation")
+	assert.NotContains(t, policyReport.GetAnnotations(), StaleAnnotation, "Expected a fresh report not to be stale")
+	assert.NotContains(t, policyReport.GetAnnotations(), DataAgeAnnotation, "Expected no data age for a fresh report")
+
+	// The fetch failed, the last report received is kept
+	data.Stale = true
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	policyReport = getPolicyReport(t)
+	assert.Equal(t, 4, countSource(policyReport, "insights"), "Expected the stale Insights results to be kept")
+	assert.Equal(t, "true", policyReport.GetAnnotations()[StaleAnnotation], "Expected the stale annotation")
+	age, err := time.ParseDuration(policyReport.GetAnnotations()[DataAgeAnnotation])
+	assert.True(t, err == nil && age >= 30*time.Minute && age <= 31*time.Minute, "Expected the data age annotation")
+
+	// The report received before a restart is not known, the results of the PolicyReport are kept
+	processor.createUpdatePolicyReports(context.TODO(),
+		types.ProcessorData{ClusterInfo: mngd, Stale: true}, fakeDynamicClient)
+	policyReport = getPolicyReport(t)
+	assert.Equal(t, 4, countSource(policyReport, "insights"), "Expected the Insights results of the PolicyReport to be kept")
+	assert.Equal(t, reportTime.UTC().Format(time.RFC3339), policyReport.GetAnnotations()[ReportTimeAnnotation],
+		"Expected the report time of the PolicyReport to be kept")
+
+	// Once REPORT_EXPIRY elapsed, the Insights results are dropped
+	processor.Store.SetReport(mngd, data.Report, store.Validators{})
+	data.ReportTime = time.Now().Add(-2 * time.Hour)
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	policyReport = getPolicyReport(t)
+	assert.Equal(t, 0, countSource(policyReport, "insights"), "Expected the expired Insights results to be dropped")
+	assert.Equal(t, 2, countSource(policyReport, "grc"), "Expected the governance results to be kept")
+	assert.Empty(t, policyReport.GetAnnotations(), "Expected the Insights annotations to be removed")
+	entry, _ := processor.Store.Get(mngd.Namespace)
+	assert.Nil(t, entry.Report, "Expected the expired report to be dropped from the store")
+}
+
+// A PolicyReport written without the report time annotation, e.g. by a previous version, keeps its Insights results
+func Test_createUpdatePolicyReports_NoReportTime(t *testing.T) {
+	t.Setenv("REPORT_EXPIRY", "1h")
+	assert.Nil(t, config.SetupConfig())
+	t.Cleanup(func() { _ = config.SetupConfig() })
+	setUp(t)
+	UnmarshalFile("createreporttest.json", &respBody, t)
+	data, _ := ret.GetPolicyInfo(respBody, mngd)
+	data.ReportTime = time.Now()
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+
+	writtenAt := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	setPolicyReport(t, func(policyReport *v1beta1.PolicyReport) {
+		policyReport.SetAnnotations(nil)
+		for i := range policyReport.Results {
+			policyReport.Results[i].Timestamp = metav1.Timestamp{Seconds: writtenAt.Unix()}
+		}
+	})
+	processor.createUpdatePolicyReports(context.TODO(),
+		types.ProcessorData{ClusterInfo: mngd, Stale: true}, fakeDynamicClient)
+	policyReport := getPolicyReport(t)
+	assert.Equal(t, 4, countSource(policyReport, "insights"), "Expected the Insights results to be kept")
+	assert.Equal(t, writtenAt.UTC().Format(time.RFC3339), policyReport.GetAnnotations()[ReportTimeAnnotation],
+		"Expected the results to be aged from the time they were written")
+
+	// Without any time, the results are kept with an unknown age
+	setPolicyReport(t, func(policyReport *v1beta1.PolicyReport) {
+		policyReport.SetAnnotations(nil)
+		for i := range policyReport.Results {
+			policyReport.Results[i].Timestamp = metav1.Timestamp{}
+		}
+	})
+	processor.createUpdatePolicyReports(context.TODO(),
+		types.ProcessorData{ClusterInfo: mngd, Stale: true}, fakeDynamicClient)
+	policyReport = getPolicyReport(t)
+	assert.Equal(t, 4, countSource(policyReport, "insights"), "Expected the Insights results of unknown age to be kept")
+	assert.Equal(t, "true", policyReport.GetAnnotations()[StaleAnnotation], "Expected the stale annotation")
+	assert.NotContains(t, policyReport.GetAnnotations(), ReportTimeAnnotation, "Expected no report time")
+}
+
+// setPolicyReport changes the PolicyReport of the managed cluster
+func setPolicyReport(t *testing.T, change func(policyReport *v1beta1.PolicyReport)) {
+	policyReport := getPolicyReport(t)
+	change(&policyReport)
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policyReport)
+	assert.Nil(t, err)
+	_, err = fakeDynamicClient.Resource(policyReportGvr).Namespace(mngd.Namespace).Update(
+		context.TODO(), &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+}
+
+func countUpdates(client *dynamicfakeclient.FakeDynamicClient) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetResource() == policyReportGvr {
+			count++
+		}
+	}
+	return count
+}
+
+func Test_createUpdatePolicyReports_Unchanged(t *testing.T) {
+	setUp(t)
+	UnmarshalFile("createreporttest.json", &respBody, t)
+	data, _ := ret.GetPolicyInfo(respBody, mngd)
+	data.Report.Meta.LastCheckedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data.ReportTime = time.Now()
+
+	// Created, then updated once to set the PolicyReport source
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	updates := countUpdates(fakeDynamicClient)
+	skippedBefore := testutil.ToFloat64(metrics.PolicyReportsUnchanged)
+
+	data.ReportTime = time.Now().Add(time.Minute)
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	assert.Equal(t, updates, countUpdates(fakeDynamicClient), "Expected an unchanged report not to be written")
+	assert.Equal(t, skippedBefore+1, testutil.ToFloat64(metrics.PolicyReportsUnchanged), "Expected the skip to be counted")
+	policyReport := getPolicyReport(t)
+	assert.Equal(t, data.ReportTime.UTC().Format(time.RFC3339), policyReport.GetAnnotations()[ReportTimeAnnotation],
+		"Expected the report time of an unchanged report to be set")
+
+	// CCX checked the cluster again
+	data.Report.Meta.LastCheckedAt = data.Report.Meta.LastCheckedAt.Add(time.Hour)
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	assert.Equal(t, updates+1, countUpdates(fakeDynamicClient), "Expected a new check to be written")
+
+	// A rule is no longer reported
+	data.Report.Data = data.Report.Data[1:]
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	assert.Equal(t, updates+2, countUpdates(fakeDynamicClient), "Expected a changed rule set to be written")
+	assert.Equal(t, 3, countSource(getPolicyReport(t), "insights"))
+
+	// The governance results are compared even when the report is unchanged
+	err := fakeDynamicClient.Resource(policyGvr).Namespace(mngd.Namespace).Delete(
+		context.TODO(), "default.policy1", metav1.DeleteOptions{})
+	assert.Nil(t, err)
+	processor.createUpdatePolicyReports(context.TODO(), data, fakeDynamicClient)
+	assert.Equal(t, updates+3, countUpdates(fakeDynamicClient), "Expected changed governance results to be written")
+	assert.Equal(t, 0, countSource(getPolicyReport(t), "grc"))
+}
+
+func Test_ProcessPolicyReports_Stops(t *testing.T) {
+	setUp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		processor.ProcessPolicyReports(ctx, fetchPolicyReports, fakeDynamicClient)
+		close(stopped)
+	}()
+
+	addReportToChannel(t, "createreporttest.json")
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ProcessPolicyReports did not return after the context was cancelled")
+	}
 }
 
 func Test_filterOpenshiftCategory(t *testing.T) {
@@ -0,0 +1,128 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/metrics"
+)
+
+// States of the circuit breaker
+const (
+	CircuitClosed   = "closed"    // CCX is called
+	CircuitOpen     = "open"      // CCX is not called until the cooldown elapsed
+	CircuitHalfOpen = "half-open" // a single request probes CCX
+)
+
+// ErrCircuitOpen is returned instead of calling CCX while the circuit breaker is open
+var ErrCircuitOpen = errors.New("CCX is unavailable, the circuit breaker is open")
+
+// circuitBreaker stops calling CCX after CIRCUIT_BREAKER_THRESHOLD consecutive failed requests, 429, 5xx or
+// network errors. Once CIRCUIT_BREAKER_COOLDOWN elapsed, a single request probes CCX: the circuit closes if
+// it succeeds and opens again if it fails.
+type circuitBreaker struct {
+	lock     sync.Mutex
+	state    string
+	failures int       // consecutive failures while closed
+	openedAt time.Time // when the circuit last opened
+	now      func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	b := &circuitBreaker{state: CircuitClosed, now: time.Now}
+	setCircuitStateMetric(CircuitClosed)
+	return b
+}
+
+// allow returns ErrCircuitOpen if the request must not be sent. After the cooldown, the first request is
+// allowed as the probe and the others are rejected until record is called for it.
+func (b *circuitBreaker) allow(cooldown time.Duration) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < cooldown {
+			return ErrCircuitOpen
+		}
+		glog.Infof("CCX circuit breaker cooldown elapsed, probing CCX")
+		b.setState(CircuitHalfOpen)
+		return nil
+	case CircuitHalfOpen:
+		// the probe is in progress
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record updates the state with the result of an allowed request. The breaker never opens when the
+// threshold is lower than 1.
+func (b *circuitBreaker) record(failed bool, threshold int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !failed {
+		if b.state != CircuitClosed {
+			glog.Info("CCX answered the probe, closing the circuit breaker")
+		}
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+	switch b.state {
+	case CircuitHalfOpen:
+		glog.Warning("CCX probe failed, opening the circuit breaker again")
+		b.open()
+	case CircuitClosed:
+		b.failures++
+		if threshold > 0 && b.failures >= threshold {
+			glog.Warningf("%d consecutive CCX requests failed, opening the circuit breaker", b.failures)
+			b.open()
+		}
+	}
+}
+
+// cancel releases the probe when the request was cancelled before CCX answered
+func (b *circuitBreaker) cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == CircuitHalfOpen {
+		b.state = CircuitOpen
+		setCircuitStateMetric(CircuitOpen)
+	}
+}
+
+// open must be called with lock held
+func (b *circuitBreaker) open() {
+	b.failures = 0
+	b.openedAt = b.now()
+	b.setState(CircuitOpen)
+}
+
+// setState must be called with lock held
+func (b *circuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	setCircuitStateMetric(state)
+}
+
+// State returns the current state
+func (b *circuitBreaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+func setCircuitStateMetric(current string) {
+	for _, state := range []string{CircuitClosed, CircuitOpen, CircuitHalfOpen} {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		metrics.CCXCircuitState.WithLabelValues(state).Set(value)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"github.com/stolostron/insights-client/pkg/store"
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := newCircuitBreaker()
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, b.allow(time.Minute))
+		b.record(true, 3)
+	}
+	b.record(false, 3)
+	assert.Equal(t, CircuitClosed, b.State(), "Test success resets the failures")
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, b.allow(time.Minute))
+		b.record(true, 3)
+	}
+	assert.Equal(t, CircuitOpen, b.State(), "Test opened after 3 consecutive failures")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.CCXCircuitState.WithLabelValues(CircuitOpen)), "Test open state metric")
+	assert.Equal(t, ErrCircuitOpen, b.allow(time.Minute), "Test request rejected during the cooldown")
+
+	now = now.Add(time.Minute)
+	assert.Nil(t, b.allow(time.Minute), "Test probe allowed after the cooldown")
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.Equal(t, ErrCircuitOpen, b.allow(time.Minute), "Test single probe")
+	b.record(true, 3)
+	assert.Equal(t, CircuitOpen, b.State(), "Test failed probe opens the circuit again")
+	assert.Equal(t, ErrCircuitOpen, b.allow(time.Minute), "Test cooldown restarted")
+
+	now = now.Add(time.Minute)
+	assert.Nil(t, b.allow(time.Minute))
+	b.cancel()
+	assert.Equal(t, CircuitOpen, b.State(), "Test cancelled probe releases the probe")
+	assert.Nil(t, b.allow(time.Minute))
+	b.record(false, 3)
+	assert.Equal(t, CircuitClosed, b.State(), "Test successful probe closes the circuit")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.CCXCircuitState.WithLabelValues(CircuitClosed)), "Test closed state metric")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.CCXCircuitState.WithLabelValues(CircuitOpen)))
+}
+
+func TestRetrieveReport_CircuitOpen(t *testing.T) {
+	t.Setenv("CCX_MAX_ATTEMPTS", "1")
+	t.Setenv("CIRCUIT_BREAKER_FAILURES", "2")
+	t.Setenv("RETRIEVAL_WORKERS", "1")
+	assert.Nil(t, config.SetupConfig())
+	t.Cleanup(func() { _ = config.SetupConfig() })
+
+	ts, requests := newRetryServer(t, nil, 503, 503, 503, 503)
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("Bearer token"))
+	input := make(chan types.ManagedClusterInfo)
+	output := make(chan types.ProcessorData, 3)
+	clusters := []types.ManagedClusterInfo{
+		{Namespace: "cluster-1", ClusterID: "id-1"},
+		{Namespace: "cluster-2", ClusterID: "id-2"},
+		{Namespace: "cluster-3", ClusterID: "id-3"},
+	}
+	refresh := ret.Store.StartRefresh([]string{"cluster-3"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ret.RetrieveReport(ctx, "testHubID", input, output,
+		needsCCX(map[string]bool{"id-1": true, "id-2": true, "id-3": true}), false)
+	assert.True(t, SendClusters(ctx, input, clusters))
+
+	assert.Eventually(t, func() bool {
+		entry, found := ret.Store.Get("cluster-3")
+		return found && entry.LastError == ErrCircuitOpen.Error()
+	}, 5*time.Second, 10*time.Millisecond, "Test skipped cluster error recorded")
+	assert.Eventually(t, func() bool {
+		refresh, _ = ret.Store.GetRefresh(refresh.ID)
+		return refresh.Status == store.RefreshFailed
+	}, 5*time.Second, 10*time.Millisecond, "Test refresh of the skipped cluster failed")
+	assert.Equal(t, map[string]string{"cluster-3": ErrCircuitOpen.Error()}, refresh.Skipped, "Test refresh skip reason")
+	assert.Equal(t, CircuitOpen, ret.CircuitState())
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests), "Test CCX not called while the circuit is open")
+	assert.Equal(t, 2, len(output), "Test nothing sent to the processor while the circuit is open")
+
+	cluster := clusters[0]
+	req, _ := ret.CreateInsightsRequest(ctx, ts.URL, cluster, "testHubID")
+	_, _, err := ret.CallInsights(req, cluster)
+	assert.True(t, errors.Is(err, ErrCircuitOpen), "Test CallInsights rejected while the circuit is open")
+	assert.False(t, isRetryable(&http.Response{}, ErrCircuitOpen), "Test open circuit not retried")
+}
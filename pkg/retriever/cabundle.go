@@ -0,0 +1,238 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TrustedCAKey - key of the PEM bundle in the trusted CA ConfigMap, injected by the Cluster Network Operator
+const TrustedCAKey = "ca-bundle.crt"
+
+// How often the CA files are checked for changes
+const caFileCheckInterval = 30 * time.Second
+
+// How long the startup waits for the trusted CA and Proxy informers, they keep syncing in the background after it
+var cacheSyncTimeout = 30 * time.Second
+
+// CABundle builds the RootCAs of the CCX requests from the system pool, the trusted CA ConfigMaps,
+// CACert and the CA files, and builds them again when a ConfigMap or a file changes.
+// An intercepting TLS proxy is trusted by adding its CA to one of them.
+type CABundle struct {
+	lock       sync.Mutex
+	caCert     []byte   // decoded CACert
+	files      []string // extra PEM files, e.g. a mounted secret
+	filesPEM   map[string][]byte
+	sourcesPEM map[string][]byte // by source, e.g. ConfigMap namespace/name
+	listeners  []func(*x509.CertPool)
+}
+
+// NewCABundle returns an error if caCert is not base64 encoded or a file can't be read
+func NewCABundle(caCert string, files []string) (*CABundle, error) {
+	b := &CABundle{files: files, filesPEM: map[string][]byte{}, sourcesPEM: map[string][]byte{}}
+	if caCert != "" {
+		decoded, err := b64.URLEncoding.DecodeString(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding CA certificate, CACert must be a base64 encoded CA certificate: %v", err)
+		}
+		b.caCert = decoded
+	}
+	if _, err := b.reloadFiles(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ParseCAFiles splits the comma separated CA_FILES setting
+func ParseCAFiles(value string) []string {
+	files := []string{}
+	for _, file := range strings.Split(value, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// OnChange registers a function called with the new pool when a ConfigMap or a file changes
+func (b *CABundle) OnChange(listener func(*x509.CertPool)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// Pool returns the system pool with the certificates of the ConfigMaps, CACert and the files added
+func (b *CABundle) Pool() *x509.CertPool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.pool()
+}
+
+// pool must be called with lock held
+func (b *CABundle) pool() *x509.CertPool {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		glog.Warningf("Unable to load the system CA certificates: %v", err)
+		pool = x509.NewCertPool()
+	}
+	sources := make([]string, 0, len(b.sourcesPEM))
+	for source := range b.sourcesPEM {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		b.appendPEM(pool, b.sourcesPEM[source], source)
+	}
+	b.appendPEM(pool, b.caCert, "CACert")
+	for _, file := range b.files {
+		b.appendPEM(pool, b.filesPEM[file], "CA file "+file)
+	}
+	return pool
+}
+
+func (b *CABundle) appendPEM(pool *x509.CertPool, pem []byte, source string) {
+	if len(pem) > 0 && !pool.AppendCertsFromPEM(pem) {
+		glog.Warningf("No PEM certificate found in the %s", source)
+	}
+}
+
+// Watch follows the trusted CA ConfigMap, given as namespace/name, and checks the files every 30 seconds
+// until the context is cancelled. Returns once the ConfigMap is loaded, so the first requests trust it,
+// or after cacheSyncTimeout when it can't be listed.
+func (b *CABundle) Watch(ctx context.Context, client kubernetes.Interface, configMap string) {
+	namespace, name, _ := strings.Cut(configMap, "/")
+	source := "ConfigMap " + configMap
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			b.setConfigMap(source, obj)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			b.setConfigMap(source, next)
+		},
+		DeleteFunc: func(obj interface{}) {
+			b.setPEM(source, nil)
+		},
+	})
+	if err != nil {
+		glog.Error("Error adding eventHandler for the trusted CA ConfigMap: ", err)
+	}
+	factory.Start(ctx.Done())
+	waitForCacheSync(ctx, source, informer.HasSynced)
+
+	if len(b.files) == 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(caFileCheckInterval):
+			}
+			changed, err := b.reloadFiles()
+			if err != nil {
+				glog.Errorf("Error reloading the CA files, keeping the current ones: %v", err)
+			}
+			if changed {
+				b.notify("CA files")
+			}
+		}
+	}()
+}
+
+// waitForCacheSync waits for the informers until cacheSyncTimeout so the startup is not blocked when the
+// resources can't be listed, e.g. missing RBAC. Returns false and logs a warning if they are not synced.
+func waitForCacheSync(ctx context.Context, resource string, synced ...cache.InformerSynced) bool {
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+	if cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		return true
+	}
+	if ctx.Err() == nil {
+		glog.Warningf("%s not loaded within %s, continuing while it is watched in the background", resource, cacheSyncTimeout)
+	}
+	return false
+}
+
+func (b *CABundle) setConfigMap(source string, obj interface{}) {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	b.setPEM(source, []byte(configMap.Data[TrustedCAKey]))
+}
+
+// setPEM builds the pool again if the bundle of the source changed, a nil bundle removes the source
+func (b *CABundle) setPEM(source string, pem []byte) {
+	b.lock.Lock()
+	unchanged := bytes.Equal(pem, b.sourcesPEM[source])
+	if pem == nil {
+		delete(b.sourcesPEM, source)
+	} else {
+		b.sourcesPEM[source] = pem
+	}
+	b.lock.Unlock()
+	if !unchanged {
+		b.notify(source)
+	}
+}
+
+// reloadFiles reads the files, returns true if one of them changed. A file that can't be read keeps its content.
+func (b *CABundle) reloadFiles() (bool, error) {
+	changed := false
+	var errs []string
+	for _, file := range b.files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("could not read CA file: %v", err))
+			continue
+		}
+		b.lock.Lock()
+		if !bytes.Equal(pem, b.filesPEM[file]) {
+			b.filesPEM[file] = pem
+			changed = true
+		}
+		b.lock.Unlock()
+	}
+	if len(errs) > 0 {
+		return changed, errors.New(strings.Join(errs, "; "))
+	}
+	return changed, nil
+}
+
+// notify calls the OnChange functions with the new pool
+func (b *CABundle) notify(source string) {
+	b.lock.Lock()
+	pool := b.pool()
+	listeners := append([]func(*x509.CertPool){}, b.listeners...)
+	b.lock.Unlock()
+	glog.Infof("The %s changed, rebuilding the trusted CA pool", source)
+	for _, listener := range listeners {
+		listener(pool)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTLSServer returns a CCX server with a self-signed certificate and the PEM of the certificate
+func newTLSServer(t *testing.T) (*httptest.Server, []byte) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": []}}`)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+}
+
+func callTLSServer(ret *Retriever, url string) error {
+	cluster := types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+	req, err := ret.CreateInsightsRequest(context.TODO(), url, cluster, "hub")
+	if err != nil {
+		return err
+	}
+	_, _, err = ret.CallInsights(req, cluster)
+	return err
+}
+
+func Test_CABundle_ConfigMap(t *testing.T) {
+	ts, caPEM := newTLSServer(t)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "trusted-ca-bundle", Namespace: "openshift-config-managed"},
+		Data:       map[string]string{TrustedCAKey: string(caPEM)},
+	}
+	client := fake.NewSimpleClientset(configMap)
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("Bearer token"))
+	assert.NotNil(t, callTLSServer(ret, ts.URL), "Test unknown CA rejected")
+
+	bundle, err := NewCABundle("", nil)
+	assert.Nil(t, err)
+	bundle.OnChange(ret.SetRootCAs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bundle.Watch(ctx, client, "openshift-config-managed/trusted-ca-bundle")
+	ret.SetRootCAs(bundle.Pool())
+	assert.Nil(t, callTLSServer(ret, ts.URL), "Test CA of the ConfigMap trusted")
+
+	configMap.Data[TrustedCAKey] = ""
+	_, err = client.CoreV1().ConfigMaps("openshift-config-managed").Update(ctx, configMap, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return callTLSServer(ret, ts.URL) != nil
+	}, 5*time.Second, 10*time.Millisecond, "Test transport rebuilt when the ConfigMap changes")
+}
+
+func Test_CABundle_Files(t *testing.T) {
+	ts, caPEM := newTLSServer(t)
+	file := filepath.Join(t.TempDir(), "ca.crt")
+	assert.Nil(t, os.WriteFile(file, []byte("not a certificate"), 0600))
+
+	bundle, err := NewCABundle("", []string{file})
+	assert.Nil(t, err)
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("Bearer token"))
+	ret.SetRootCAs(bundle.Pool())
+	assert.NotNil(t, callTLSServer(ret, ts.URL), "Test file without the CA")
+
+	assert.Nil(t, os.WriteFile(file, caPEM, 0600))
+	changed, err := bundle.reloadFiles()
+	assert.Nil(t, err)
+	assert.True(t, changed, "Test changed file detected")
+	ret.SetRootCAs(bundle.Pool())
+	assert.Nil(t, callTLSServer(ret, ts.URL), "Test CA of the file trusted")
+
+	changed, _ = bundle.reloadFiles()
+	assert.False(t, changed, "Test unchanged file")
+	_, err = NewCABundle("", []string{filepath.Join(t.TempDir(), "missing.crt")})
+	assert.NotNil(t, err, "Test missing file rejected")
+}
+
+func Test_NewCABundle_CACert(t *testing.T) {
+	_, err := NewCABundle("not base64!", nil)
+	assert.NotNil(t, err, "Test invalid CACert rejected")
+}
+
+func Test_ParseCAFiles(t *testing.T) {
+	assert.Equal(t, []string{"/etc/a.crt", "/etc/b.crt"}, ParseCAFiles(" /etc/a.crt, ,/etc/b.crt"))
+	assert.Equal(t, []string{}, ParseCAFiles(""))
+}
@@ -0,0 +1,231 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"sync"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/http/httpproxy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ProxyName - name of the cluster-wide Proxy resource
+const ProxyName = "cluster"
+
+// Namespace of the ConfigMap named by the trustedCA of the Proxy
+const proxyCANamespace = "openshift-config"
+
+// Source of the Proxy CAs in the CABundle, replaced when the trustedCA is renamed
+const proxyCASource = "trustedCA ConfigMap of the Proxy"
+
+var proxyGvr = schema.GroupVersionResource{
+	Group:    "config.openshift.io",
+	Version:  "v1",
+	Resource: "proxies",
+}
+
+// ProxyWatcher applies the cluster-wide OpenShift Proxy to the CCX requests. When the Proxy sets httpProxy
+// or httpsProxy, they are used with noProxy instead of the proxy environment variables. The ConfigMap named
+// by trustedCA is added to the trusted CAs. Changes are applied without restarting.
+type ProxyWatcher struct {
+	lock       sync.Mutex
+	retriever  *Retriever
+	bundle     *CABundle
+	proxy      *httpproxy.Config // nil when the Proxy sets no proxy
+	trustedCA  string            // name of the ConfigMap in openshift-config, empty when not set
+	configMaps corelisters.ConfigMapLister
+}
+
+// NewProxyWatcher ...
+func NewProxyWatcher(retriever *Retriever, bundle *CABundle) *ProxyWatcher {
+	return &ProxyWatcher{retriever: retriever, bundle: bundle}
+}
+
+// Watch follows the Proxy named ProxyName and the ConfigMaps of openshift-config until the context is
+// cancelled. Returns once the Proxy is loaded, so the first requests use it, after cacheSyncTimeout when it
+// can't be listed, or right away when the cluster doesn't serve the Proxy API.
+func (p *ProxyWatcher) Watch(ctx context.Context, dynamicClient dynamic.Interface, kubeClient kubernetes.Interface) {
+	if !isProxyAPIServed(kubeClient) {
+		glog.Info("The cluster has no config.openshift.io Proxy, using the proxy environment variables")
+		return
+	}
+
+	// The trustedCA ConfigMap can be renamed, every ConfigMap of the namespace is watched
+	kubeFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0, informers.WithNamespace(proxyCANamespace))
+	configMapInformer := kubeFactory.Core().V1().ConfigMaps()
+	p.lock.Lock()
+	p.configMaps = configMapInformer.Lister()
+	p.lock.Unlock()
+	_, err := configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.configMapChanged(obj)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			p.configMapChanged(next)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			p.configMapChanged(obj)
+		},
+	})
+	if err != nil {
+		glog.Error("Error adding eventHandler for the openshift-config ConfigMaps: ", err)
+	}
+	kubeFactory.Start(ctx.Done())
+	// The trustedCA ConfigMap is added when its informer syncs, the Proxy is watched meanwhile
+	waitForCacheSync(ctx, "ConfigMaps of "+proxyCANamespace, configMapInformer.Informer().HasSynced)
+	if ctx.Err() != nil {
+		return
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, metav1.NamespaceAll,
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ProxyName).String()
+		},
+	)
+	informer := factory.ForResource(proxyGvr).Informer()
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.apply(obj)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			p.apply(next)
+		},
+		DeleteFunc: func(obj interface{}) {
+			p.apply(nil)
+		},
+	})
+	if err != nil {
+		glog.Error("Error adding eventHandler for the Proxy: ", err)
+	}
+	factory.Start(ctx.Done())
+	if waitForCacheSync(ctx, "Proxy "+ProxyName, informer.HasSynced) {
+		glog.Info("Loaded the cluster-wide Proxy")
+	}
+}
+
+func isProxyAPIServed(kubeClient kubernetes.Interface) bool {
+	resources, err := kubeClient.Discovery().ServerResourcesForGroupVersion(proxyGvr.GroupVersion().String())
+	if err != nil {
+		return false
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == proxyGvr.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// proxySettings returns the proxy and the trustedCA ConfigMap name of the Proxy. The status has the proxies
+// validated by the network operator and the default noProxy entries, the spec is used until it is set.
+func proxySettings(u *unstructured.Unstructured) (*httpproxy.Config, string) {
+	if u == nil {
+		return nil, ""
+	}
+	trustedCA, _, _ := unstructured.NestedString(u.Object, "spec", "trustedCA", "name")
+	source := "status"
+	if !hasProxy(u, source) {
+		source = "spec"
+	}
+	if !hasProxy(u, source) {
+		return nil, trustedCA
+	}
+	proxy := &httpproxy.Config{}
+	proxy.HTTPProxy, _, _ = unstructured.NestedString(u.Object, source, "httpProxy")
+	proxy.HTTPSProxy, _, _ = unstructured.NestedString(u.Object, source, "httpsProxy")
+	proxy.NoProxy, _, _ = unstructured.NestedString(u.Object, source, "noProxy")
+	return proxy, trustedCA
+}
+
+func hasProxy(u *unstructured.Unstructured, source string) bool {
+	httpProxy, _, _ := unstructured.NestedString(u.Object, source, "httpProxy")
+	httpsProxy, _, _ := unstructured.NestedString(u.Object, source, "httpsProxy")
+	return httpProxy != "" || httpsProxy != ""
+}
+
+// apply updates the transport and the trusted CAs when the Proxy changed, obj is nil when it is deleted
+func (p *ProxyWatcher) apply(obj interface{}) {
+	u, _ := obj.(*unstructured.Unstructured)
+	proxy, trustedCA := proxySettings(u)
+
+	p.lock.Lock()
+	proxyChanged := !reflect.DeepEqual(proxy, p.proxy)
+	caChanged := trustedCA != p.trustedCA
+	p.proxy = proxy
+	p.trustedCA = trustedCA
+	p.lock.Unlock()
+
+	if proxyChanged {
+		if proxy == nil {
+			glog.Info("The cluster-wide proxy is not set, using the proxy environment variables")
+		} else {
+			glog.Infof("Using the cluster-wide proxy: httpProxy=%q httpsProxy=%q noProxy=%q",
+				redactURL(proxy.HTTPProxy), redactURL(proxy.HTTPSProxy), proxy.NoProxy)
+		}
+		p.retriever.SetProxy(proxy)
+	}
+	if caChanged {
+		p.loadTrustedCA()
+	}
+}
+
+func (p *ProxyWatcher) configMapChanged(obj interface{}) {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	p.lock.Lock()
+	trustedCA := p.trustedCA
+	p.lock.Unlock()
+	if trustedCA != "" && configMap.GetName() == trustedCA {
+		p.loadTrustedCA()
+	}
+}
+
+// loadTrustedCA replaces the Proxy CAs in the bundle with the current trustedCA ConfigMap
+func (p *ProxyWatcher) loadTrustedCA() {
+	p.lock.Lock()
+	trustedCA := p.trustedCA
+	configMaps := p.configMaps
+	p.lock.Unlock()
+
+	var pem []byte
+	if trustedCA != "" && configMaps != nil {
+		configMap, err := configMaps.ConfigMaps(proxyCANamespace).Get(trustedCA)
+		if err == nil {
+			pem = []byte(configMap.Data[TrustedCAKey])
+		} else if errors.IsNotFound(err) {
+			glog.Warningf("The trustedCA ConfigMap %s/%s of the Proxy doesn't exist", proxyCANamespace, trustedCA)
+		} else {
+			glog.Errorf("Error reading the trustedCA ConfigMap %s/%s of the Proxy: %v", proxyCANamespace, trustedCA, err)
+		}
+	}
+	p.bundle.setPEM(proxyCASource, pem)
+}
+
+// redactURL hides the password of a proxy URL in the logs
+func redactURL(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return proxy
+	}
+	return u.Redacted()
+}
@@ -5,26 +5,26 @@ package retriever
 
 import (
 	"context"
-	"crypto/tls"
 	"crypto/x509"
-	b64 "encoding/base64"
 	"encoding/json"
-	e "errors"
+	"errors"
 	"fmt"
 	"io"
-	"net"
+	"math"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/clientconfig"
 	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/metrics"
 	"github.com/stolostron/insights-client/pkg/monitor"
+	"github.com/stolostron/insights-client/pkg/store"
 	"github.com/stolostron/insights-client/pkg/types"
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	knet "k8s.io/apimachinery/pkg/util/net"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/time/rate"
 	"k8s.io/client-go/dynamic"
 )
 
@@ -34,187 +34,199 @@ var lock = sync.RWMutex{}
 type Retriever struct {
 	ReportUrl       string
 	Client          *http.Client
-	Token           string // token to connect to CRC
+	Tokens          credentials.TokenProvider       // token to connect to CRC
+	Credentials     *credentials.ClusterCredentials // credentials of the clusters of other organizations, Tokens when nil
 	DisconnectedEnv bool
-}
+	Store           *store.ReportStore       // latest report received for each cluster
+	ClientConfig    *clientconfig.Controller // selects the clusters reports are fetched for
 
-type serializedAuthMap struct {
-	Auths map[string]serializedAuth `json:"auths"`
-}
-type serializedAuth struct {
-	Auth string `json:"auth"`
+	transport          *transport        // rebuilt when the trusted CAs change, nil when the client is given
+	limiter            *rate.Limiter     // CCX requests per second shared by the workers
+	breaker            *circuitBreaker   // stops calling CCX during an outage
+	lastSuccessfulCall time.Time         // time of the last successful CallInsights
+	acceptedTokens     map[string]string // last token accepted by CCX for each credentials name
+	invalidatedTokens  map[string]string // last token invalidated after a 401 for each credentials name
 }
 
-// NewRetriever ...
+// NewRetriever ... When client is nil, the requests trust the system CAs until SetRootCAs is called.
 func NewRetriever(ReportUrl string, client *http.Client,
-	token string) *Retriever {
+	tokens credentials.TokenProvider) *Retriever {
+	var clientTransport *transport
 	if client == nil {
-		clientTransport := &http.Transport{
-			Proxy: knet.NewProxierWithNoProxyCIDR(http.ProxyFromEnvironment),
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			TLSHandshakeTimeout: 10 * time.Second,
-			DisableKeepAlives:   true,
-		}
-		if config.Cfg.CACert != "" {
-			// If caCert is defiend in Insights-client deployment - we need to use it in http client
-			decodedCert, err := b64.URLEncoding.DecodeString(config.Cfg.CACert)
-			if err != nil {
-				// Exit because this is an unrecoverable configuration problem.
-				glog.Fatal("Error decoding CA certificate. Certificate must be a base64 encoded CA certificate. Error: ", err)
-			}
-			caCertPool := x509.NewCertPool()
-			caCertPool.AppendCertsFromPEM(decodedCert)
-
-			tlsCfg := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-				RootCAs:    caCertPool,
-			}
-			clientTransport.TLSClientConfig = tlsCfg
-		}
+		clientTransport = newTransport()
 		client = &http.Client{Transport: clientTransport}
 	}
 	r := &Retriever{
-		Client:    client,
-		ReportUrl: ReportUrl,
-	}
-	if token == "" {
-		r.DisconnectedEnv = r.setUpRetriever()
-	} else {
-		r.Token = token
-		r.DisconnectedEnv = false
+		Client:       client,
+		ReportUrl:    ReportUrl,
+		Store:        store.NewReportStore(),
+		ClientConfig: clientconfig.NewController(),
+		Tokens:       tokens,
+
+		transport:         clientTransport,
+		limiter:           rate.NewLimiter(requestLimit(config.Get().RequestsPerSecond())),
+		breaker:           newCircuitBreaker(),
+		acceptedTokens:    map[string]string{},
+		invalidatedTokens: map[string]string{},
 	}
+	r.DisconnectedEnv = r.setUpRetriever()
 	return r
 }
 
-// Get CRC token , wait until we can get token
+// Get CRC token , wait until we can get token. A cluster without a cloud.openshift.com pull-secret token
+// is disconnected, the other providers are configured explicitly and their errors are retried on each poll.
 func (r *Retriever) setUpRetriever() bool {
-	err := r.StartTokenRefresh()
+	if _, ok := r.Tokens.(*credentials.PullSecretProvider); !ok {
+		return false
+	}
+	_, err := r.Tokens.Token(context.TODO())
 	refreshCounter := 0
 	for err != nil && refreshCounter < 12 {
 		glog.Warningf("Unable to get CRC Token: %v", err)
 		time.Sleep(5 * time.Second)
 		refreshCounter += 1
+		_, err = r.Tokens.Token(context.TODO())
 	}
-	if refreshCounter == 12 {
+	if err != nil {
 		glog.Warning("Could not get token from CCX server after 1 minute, treating env as disconnected")
 		return true
 	}
 	return false
 }
 
-// StartTokenRefresh sets the CRC token for use in Insights queries
-func (r *Retriever) StartTokenRefresh() error {
-	glog.Infof("Refreshing CRC credentials  ")
-	secret, err := config.GetKubeClient().CoreV1().Secrets("openshift-config").
-		Get(context.TODO(), "pull-secret", metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			glog.V(2).Infof("pull-secret does not exist")
-			err = fmt.Errorf("pull-secret does not exist in openshift-config namespace: %v", err)
-		} else if errors.IsForbidden(err) {
-			glog.V(2).Infof("Operator does not have permission to check pull-secret: %v", err)
-			err = fmt.Errorf("operator does not have permission to check pull-secret: %v", err)
-		} else {
-			err = fmt.Errorf("could not check pull-secret: %v", err)
-		}
-		return err
-	}
-	if secret != nil {
-		if data := secret.Data[".dockerconfigjson"]; len(data) > 0 {
-			var pullSecret serializedAuthMap
-			if err := json.Unmarshal(data, &pullSecret); err != nil {
-				glog.Errorf("Unable to unmarshal cluster pull-secret: %v", err)
-				err = fmt.Errorf("unable to unmarshal cluster pull-secret: %v", err)
-				return err
-			}
-			if auth, ok := pullSecret.Auths["cloud.openshift.com"]; ok {
-				token := strings.TrimSpace(auth.Auth)
-				if strings.Contains(token, "\n") || strings.Contains(token, "\r") {
-					return fmt.Errorf("cluster authorization token is not valid: contains newlines")
-				}
-				if len(token) > 0 {
-					glog.V(2).Info("Found cloud.openshift.com token ")
-					r.Token = "Bearer " + token
-					return nil
-				}
-			} else {
-				return fmt.Errorf("cloud.openshift.com token is not found")
-			}
-		} else {
-			return fmt.Errorf(".dockerconfigjson token is not found")
-		}
-	} else {
-		return fmt.Errorf("could not get pull-secret")
+// RetrieveReport retrieves the reports of RETRIEVAL_WORKERS clusters concurrently, the CCX requests
+// share the REQUEST_RATE budget. needsCCX is Monitor.NeedsCCX, which locks the monitor state.
+// Returns when the context is cancelled, aborting the CCX requests in progress.
+func (r *Retriever) RetrieveReport(
+	ctx context.Context,
+	hubID string,
+	input chan types.ManagedClusterInfo,
+	output chan types.ProcessorData,
+	needsCCX func(clusterID string) bool,
+	isDisconnected bool,
+) {
+	workers := max(config.Get().Workers, 1)
+	glog.Infof("Starting %d report retrieval workers", workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.retrieveReports(ctx, hubID, input, output, needsCCX, isDisconnected)
+		}()
 	}
-	return fmt.Errorf("unknown error during TokenRefresh")
+	wg.Wait()
 }
 
-func clusterNeedsCCX(cluster types.ManagedClusterInfo, clusterCCXMap map[string]bool) bool {
-	lock.Lock()
-	defer lock.Unlock()
-	needsCCX := clusterCCXMap[cluster.ClusterID]
-	return needsCCX
-}
-
-// RetrieveReport ...
-func (r *Retriever) RetrieveReport(
+// retrieveReports is a worker of RetrieveReport
+func (r *Retriever) retrieveReports(
+	ctx context.Context,
 	hubID string,
 	input chan types.ManagedClusterInfo,
 	output chan types.ProcessorData,
-	clusterCCXMap map[string]bool,
+	needsCCX func(clusterID string) bool,
 	isDisconnected bool,
 ) {
 	for {
-		cluster := <-input
+		var cluster types.ManagedClusterInfo
+		select {
+		case <-ctx.Done():
+			glog.Info("Stopping report retrieval")
+			return
+		case cluster = <-input:
+		}
 		// If the cluster id is empty do nothing
 		if cluster.Namespace == "" || cluster.ClusterID == "" {
 			continue
 		}
+		retrievedAt := time.Now()
 
-		if !clusterNeedsCCX(cluster, clusterCCXMap) || isDisconnected {
+		if !needsCCX(cluster.ClusterID) || isDisconnected {
 			glog.Infof("Retrieve Report for cluster %s", cluster.Namespace)
-			output <- types.ProcessorData{
+			sendProcessorData(ctx, output, types.ProcessorData{
 				ClusterInfo: cluster,
 				Report:      types.ReportBody{},
-			}
+				RetrievedAt: retrievedAt,
+			})
 			continue
 		}
 
 		glog.Infof("Retrieve CCX Report for cluster %s", cluster.Namespace)
-		req, err := r.CreateInsightsRequest(context.TODO(), r.ReportUrl, cluster, hubID)
+		req, err := r.CreateInsightsRequest(ctx, r.GetReportURL(), cluster, hubID)
 		if err != nil {
-			handleCCXRequestErr(err, "Error creating HttpRequest for cluster %s (%s), %v", output, cluster)
+			r.handleCCXRequestErr(ctx, err, "Error creating HttpRequest for cluster %s (%s), %v", output, cluster, retrievedAt)
 			continue
 		}
-		response, err := r.CallInsights(req, cluster)
+		response, validators, err := r.CallInsights(req, cluster)
 		if err != nil {
-			handleCCXRequestErr(err, "Error getting good Response for cluster %s (%s), %v", output, cluster)
+			if ctx.Err() != nil {
+				glog.Infof("CCX request for cluster %s aborted, stopping report retrieval", cluster.Namespace)
+				return
+			}
+			if errors.Is(err, ErrCircuitOpen) {
+				// The PolicyReport keeps its results until CCX is available again
+				glog.V(2).Infof("Not retrieving the report of cluster %s: %v", cluster.Namespace, err)
+				r.Store.SetError(cluster, err)
+				r.Store.SkipRefresh(cluster.Namespace, retrievedAt, err.Error())
+				continue
+			}
+			r.handleCCXRequestErr(ctx, err, "Error getting good Response for cluster %s (%s), %v", output, cluster, retrievedAt)
 			continue
 		}
 
 		policyReports, err := r.GetPolicyInfo(response, cluster)
 		if err != nil {
-			handleCCXRequestErr(err, "Error creating PolicyInfo for cluster %s (%s), %v", output, cluster)
+			r.handleCCXRequestErr(ctx, err, "Error creating PolicyInfo for cluster %s (%s), %v", output, cluster, retrievedAt)
 			continue
 		}
-		output <- policyReports
+		r.Store.SetReport(cluster, policyReports.Report, validators)
+		policyReports.RetrievedAt = retrievedAt
+		policyReports.ReportTime = time.Now()
+		sendProcessorData(ctx, output, policyReports)
+	}
+}
+
+// sendProcessorData sends the data to the processor unless the context is cancelled first
+func sendProcessorData(ctx context.Context, output chan types.ProcessorData, data types.ProcessorData) {
+	pending := metrics.PipelinePending.WithLabelValues(metrics.StageProcess)
+	pending.Inc()
+	defer pending.Dec()
+	select {
+	case <-ctx.Done():
+	case output <- data:
 	}
 }
 
-func handleCCXRequestErr(
+// handleCCXRequestErr sends the last report received for the cluster marked as stale, the processor keeps
+// its results until REPORT_EXPIRY. Without a report since the start, the processor keeps the Insights results
+// of the PolicyReport, aged from its report time annotation, or else from the time they were written.
+func (r *Retriever) handleCCXRequestErr(
+	ctx context.Context,
 	err error,
 	message string,
 	output chan types.ProcessorData,
 	cluster types.ManagedClusterInfo,
+	retrievedAt time.Time,
 ) {
 	glog.Warningf(message, cluster.Namespace, cluster.ClusterID, err)
-	output <- types.ProcessorData{
+	r.Store.SetError(cluster, err)
+	data := types.ProcessorData{
 		ClusterInfo: cluster,
 		Report:      types.ReportBody{},
+		RetrievedAt: retrievedAt,
+		Stale:       true,
+	}
+	if entry, found := r.lastReport(cluster); found {
+		data.Report = *entry.Report
+		data.ReportTime = entry.ReportTime
 	}
+	sendProcessorData(ctx, output, data)
+}
+
+// lastReport returns the state of the cluster when a report was received for it
+func (r *Retriever) lastReport(cluster types.ManagedClusterInfo) (store.ClusterReport, bool) {
+	entry, found := r.Store.Get(cluster.Namespace)
+	return entry, found && entry.Report != nil && entry.ClusterInfo.ClusterID == cluster.ClusterID
 }
 
 // CreateInsightsRequest ...
@@ -230,7 +242,7 @@ func (r *Retriever) CreateInsightsRequest(
 		cluster.ClusterID,
 		endpoint+"/cluster/"+cluster.ClusterID+"/reports",
 	)
-	req, err := http.NewRequest("GET", endpoint+"/cluster/"+cluster.ClusterID+"/reports", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"/cluster/"+cluster.ClusterID+"/reports", nil)
 	if err != nil {
 		glog.Warningf("Error creating HttpRequest for cluster %s (%s), %v", cluster.Namespace, cluster.ClusterID, err)
 		return nil, err
@@ -238,21 +250,58 @@ func (r *Retriever) CreateInsightsRequest(
 	// userAgent for value will be updated to insights-client once the
 	// the task https://github.com/RedHatInsights/insights-results-smart-proxy/issues/450
 	// is completed
+	tokens, credentialsName := r.tokensFor(cluster)
+	token, err := tokens.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the CRC token of the %s credentials: %v", credentialsName, err)
+	}
 	userAgent := "acm-operator/v2.3.0 cluster/" + hubID
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Authorization", r.Token)
+	req.Header.Set("Authorization", token)
+	// CCX answers 304 Not Modified when the last report received is still current
+	if entry, found := r.lastReport(cluster); found {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
 	return req, nil
 }
 
-// CallInsights ...
-func (r *Retriever) CallInsights(req *http.Request, cluster types.ManagedClusterInfo) (types.ResponseBody, error) {
+// CallInsights ... Each attempt waits for the request budget, the failures are retried as described in send.
+// Returns the report with the validators of the response, to be stored with it. When CCX answers 304 Not Modified,
+// the last report received is returned with its validators.
+func (r *Retriever) CallInsights(
+	req *http.Request,
+	cluster types.ManagedClusterInfo,
+) (types.ResponseBody, store.Validators, error) {
 	glog.V(2).Infof("Starting CallInsights for cluster %s (%s)", cluster.Namespace, cluster.ClusterID)
 	var responseBody types.ResponseBody
-	res, err := r.Client.Do(req)
+	res, err := r.send(req, cluster)
+	if errors.Is(err, ErrCircuitOpen) {
+		return types.ResponseBody{}, store.Validators{}, err
+	}
 	if err != nil {
 		glog.Warningf("Error sending HttpRequest for cluster %s (%s), %v", cluster.Namespace, cluster.ClusterID, err)
-		return types.ResponseBody{}, err
+		return types.ResponseBody{}, store.Validators{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode == http.StatusNotModified {
+		entry, found := r.lastReport(cluster)
+		if !found {
+			return types.ResponseBody{}, store.Validators{},
+				errors.New("CCX answered 304 Not Modified but no report was received before")
+		}
+		glog.V(2).Infof("Report of cluster %s (%s) not modified", cluster.Namespace, cluster.ClusterID)
+		r.setLastSuccessfulCall(time.Now())
+		r.tokenAccepted(cluster, req.Header.Get("Authorization"))
+		validators := store.Validators{ETag: entry.ETag, LastModified: entry.LastModified}
+		return types.ResponseBody{Report: *entry.Report, Status: "ok"}, validators, nil
 	}
 	if res.StatusCode != 200 {
 		glog.Warningf(
@@ -265,25 +314,131 @@ func (r *Retriever) CallInsights(req *http.Request, cluster types.ManagedCluster
 			glog.Infof("Check OCM Console - cluster should be registered in CCX server %v", cluster.ClusterID)
 		}
 		if res.StatusCode == 401 {
-			glog.Infof("Check OCM Console - Hub cluster and managed cluster should be reqistered with IDs from Same Org %v", cluster.ClusterID)
+			_, credentialsName := r.tokensFor(cluster)
+			glog.Warningf("CCX rejected the %s credentials for cluster %s (%s). Check OCM Console - Hub cluster and managed "+
+				"cluster should be reqistered with IDs from Same Org, or map the cluster to the credentials of its Org",
+				credentialsName, cluster.Namespace, cluster.ClusterID)
+			r.tokenRejected(cluster, req.Header.Get("Authorization"))
+			return types.ResponseBody{}, store.Validators{}, fmt.Errorf(
+				"CCX rejected the %s credentials, the cluster may belong to another organization",
+				credentialsName)
 		}
 		glog.V(2).Infof("Response status for report %v", res.Status)
 		glog.V(3).Infof("Response body for report  %v", req.Body)
 		glog.V(3).Infof("Response header for report %v", req.Header)
-		return types.ResponseBody{}, e.New("no Success HTTP Response code ")
+		return types.ResponseBody{}, store.Validators{}, &StatusError{StatusCode: res.StatusCode}
 	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(res.Body)
 	data, _ := io.ReadAll(res.Body)
 	// unmarshal response data into the ResponseBody struct
 	unmarshalError := json.Unmarshal(data, &responseBody)
 	if unmarshalError != nil {
 		glog.Errorf("Error unmarshalling ResponseBody %v", unmarshalError)
-		return types.ResponseBody{}, unmarshalError
+		return types.ResponseBody{}, store.Validators{}, unmarshalError
 	}
 	glog.V(2).Info("Successfully called insights. Returning the response body.")
-	return responseBody, err
+	r.setLastSuccessfulCall(time.Now())
+	r.tokenAccepted(cluster, req.Header.Get("Authorization"))
+	validators := store.Validators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+	return responseBody, validators, err
+}
+
+// tokenAccepted records the token CCX accepted, a 401 for the same token is an organization mismatch
+func (r *Retriever) tokenAccepted(cluster types.ManagedClusterInfo, token string) {
+	_, credentialsName := r.tokensFor(cluster)
+	lock.Lock()
+	defer lock.Unlock()
+	r.acceptedTokens[credentialsName] = token
+}
+
+// tokenRejected invalidates the token in case it expired or was revoked, so the next request gets a new one.
+// A token already accepted for another cluster is kept, the rejected cluster belongs to another organization.
+// A token is only invalidated once, the clusters of other organizations don't get a new token each.
+func (r *Retriever) tokenRejected(cluster types.ManagedClusterInfo, token string) {
+	tokens, credentialsName := r.tokensFor(cluster)
+	lock.Lock()
+	if r.acceptedTokens[credentialsName] == token || r.invalidatedTokens[credentialsName] == token {
+		lock.Unlock()
+		return
+	}
+	r.invalidatedTokens[credentialsName] = token
+	lock.Unlock()
+	tokens.Invalidate()
+}
+
+// tokensFor returns the token provider of the cluster and the name of its credentials
+func (r *Retriever) tokensFor(cluster types.ManagedClusterInfo) (credentials.TokenProvider, string) {
+	if r.Credentials == nil {
+		return r.Tokens, credentials.DefaultCredentials
+	}
+	return r.Credentials.ForCluster(cluster.Namespace)
+}
+
+// GetReportURL returns the CCX server the reports are requested from
+func (r *Retriever) GetReportURL() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return r.ReportUrl
+}
+
+// SetReportURL changes the CCX server used by the next requests
+func (r *Retriever) SetReportURL(reportURL string) {
+	lock.Lock()
+	defer lock.Unlock()
+	r.ReportUrl = reportURL
+}
+
+// SetRootCAs rebuilds the transport of the CCX requests to trust the pool, e.g. the CABundle pool.
+// Does nothing when the client was given to NewRetriever.
+func (r *Retriever) SetRootCAs(rootCAs *x509.CertPool) {
+	if r.transport == nil {
+		glog.Warning("Not changing the trusted CAs of the HTTP client given to the retriever")
+		return
+	}
+	r.transport.setRootCAs(rootCAs)
+}
+
+// SetRequestRate changes the CCX requests per second shared by the workers
+func (r *Retriever) SetRequestRate(requestsPerSecond float64) {
+	limit, burst := requestLimit(requestsPerSecond)
+	r.limiter.SetLimit(limit)
+	r.limiter.SetBurst(burst)
+}
+
+// requestLimit returns the limit and the burst of the request budget, at most one second of requests is
+// sent at once. The requests are not limited when the budget is not set.
+func requestLimit(requestsPerSecond float64) (rate.Limit, int) {
+	if requestsPerSecond <= 0 {
+		return rate.Inf, 1
+	}
+	return rate.Limit(requestsPerSecond), int(math.Max(1, math.Ceil(requestsPerSecond)))
+}
+
+// SetProxy rebuilds the transport of the CCX requests to use the proxy, or the proxy environment variables
+// when nil. Does nothing when the client was given to NewRetriever.
+func (r *Retriever) SetProxy(proxy *httpproxy.Config) {
+	if r.transport == nil {
+		glog.Warning("Not changing the proxy of the HTTP client given to the retriever")
+		return
+	}
+	r.transport.setProxy(proxy)
+}
+
+// CircuitState returns the state of the CCX circuit breaker: CircuitClosed, CircuitOpen or CircuitHalfOpen
+func (r *Retriever) CircuitState() string {
+	return r.breaker.State()
+}
+
+// LastSuccessfulCall returns the time of the last successful CallInsights, zero if none succeeded yet
+func (r *Retriever) LastSuccessfulCall() time.Time {
+	lock.RLock()
+	defer lock.RUnlock()
+	return r.lastSuccessfulCall
+}
+
+func (r *Retriever) setLastSuccessfulCall(t time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+	r.lastSuccessfulCall = t
 }
 
 // GetPolicyInfo ...
@@ -318,29 +473,83 @@ func (r *Retriever) GetPolicyInfo(
 	}, nil
 }
 
-// FetchClusters forwards the managed clusters to RetrieveCCXReports function
+// FetchClusters forwards the managed clusters to RetrieveCCXReports function until the context is cancelled.
+// The clusters no longer selected by the InsightsClientConfig are sent once to the processor to remove their results.
 func (r *Retriever) FetchClusters(
+	ctx context.Context,
 	monitor *monitor.Monitor,
 	input chan types.ManagedClusterInfo,
-	refreshToken bool,
+	output chan types.ProcessorData,
 	hubID string,
 	dynamicClient dynamic.Interface,
 ) {
-	ticker := time.NewTicker(monitor.ClusterPollInterval)
+	ticker := time.NewTicker(monitor.GetPollInterval())
 	defer ticker.Stop()
-	for ; true; <-ticker.C {
-		if refreshToken {
-			err := r.StartTokenRefresh()
-			if err != nil {
-				glog.Warningf("Unable to get CRC Token, Using previous Token: %v", err)
+	// Clusters not selected whose results were removed since the start
+	deselected := map[string]bool{}
+	for {
+		clusters := monitor.GetManagedClusterInfo()
+		// Forget the reports of clusters that are no longer managed
+		r.Store.Retain(clusters)
+		selected := []types.ManagedClusterInfo{}
+		for _, cluster := range clusters {
+			if !r.ClientConfig.SelectsCluster(monitor.GetClusterLabels(cluster.Namespace)) {
+				glog.V(2).Infof("Skipping cluster %s not selected by the InsightsClientConfig", cluster.Namespace)
+				r.Store.SkipRefresh(cluster.Namespace, time.Now(), store.SkipNotSelected)
+				if !deselected[cluster.Namespace] {
+					deselected[cluster.Namespace] = true
+					sendProcessorData(ctx, output, types.ProcessorData{
+						ClusterInfo: cluster,
+						RetrievedAt: time.Now(),
+						Deselected:  true,
+					})
+				}
+				continue
 			}
+			delete(deselected, cluster.Namespace)
+			selected = append(selected, cluster)
 		}
-		if len(monitor.GetManagedClusterInfo()) > 0 {
-			for _, cluster := range monitor.GetManagedClusterInfo() {
-				glog.Infof("Starting to get  cluster report for  %s", cluster)
-				input <- cluster
-				time.Sleep(time.Duration(config.Cfg.RequestInterval) * time.Second)
-			}
+		if !SendClusters(ctx, input, selected) {
+			return
+		}
+		if !waitForNextPoll(ctx, monitor, ticker) {
+			glog.Info("Stopping cluster report scheduling")
+			return
+		}
+	}
+}
+
+// SendClusters sends the clusters to the retrieval workers, each cluster is sent once a worker is free.
+// Returns false if the context is cancelled.
+func SendClusters(ctx context.Context, input chan<- types.ManagedClusterInfo, clusters []types.ManagedClusterInfo) bool {
+	pending := metrics.PipelinePending.WithLabelValues(metrics.StageRetrieve)
+	pending.Add(float64(len(clusters)))
+	for i, cluster := range clusters {
+		glog.Infof("Starting to get  cluster report for  %s", cluster)
+		select {
+		case <-ctx.Done():
+			pending.Sub(float64(len(clusters) - i))
+			return false
+		case input <- cluster:
+			pending.Dec()
+		}
+	}
+	return true
+}
+
+// waitForNextPoll waits for the ticker, which is reset when the poll interval changes.
+// Returns false if the context is cancelled.
+func waitForNextPoll(ctx context.Context, monitor *monitor.Monitor, ticker *time.Ticker) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			return true
+		case <-monitor.PollIntervalChanged():
+			interval := monitor.GetPollInterval()
+			glog.Infof("Poll interval changed, fetching the next reports in %s", interval)
+			ticker.Reset(interval)
 		}
 	}
 }
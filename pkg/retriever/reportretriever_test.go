@@ -6,9 +6,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/metrics"
 	"github.com/stolostron/insights-client/pkg/monitor"
 	"github.com/stolostron/insights-client/pkg/types"
 	"github.com/stretchr/testify/assert"
@@ -17,6 +22,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	dynamicfakeclient "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
 )
 
@@ -61,7 +68,7 @@ func TestCallInsights(t *testing.T) {
 	ts.EnableHTTP2 = true
 	defer ts.Close()
 
-	ret := NewRetriever(ts.URL, nil, "testToken")
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
 	req, _ := ret.CreateInsightsRequest(
 		context.TODO(),
 		ts.URL,
@@ -75,10 +82,12 @@ func TestCallInsights(t *testing.T) {
 		t.Errorf("Header Authorization not formed correct    : %s", req.Header.Get("Authorization"))
 	}
 
-	response, _ := ret.CallInsights(req, types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"})
+	requestsBefore := testutil.ToFloat64(metrics.CCXRequests.WithLabelValues("200"))
+	response, _, _ := ret.CallInsights(req, types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"})
 	if len(response.Report.Data) != 1 {
 		t.Errorf("Unexpected Report length %d", len(response.Report.Data))
 	}
+	assert.Equal(t, requestsBefore+1, testutil.ToFloat64(metrics.CCXRequests.WithLabelValues("200")), "Test CCX requests counter")
 
 }
 
@@ -101,9 +110,12 @@ func Test_FetchClusters(t *testing.T) {
 
 	fetchClusterIDs := make(chan types.ManagedClusterInfo)
 
-	ret := NewRetriever("testServer", nil, "testToken")
+	ret := NewRetriever("testServer", nil, credentials.NewStaticProvider("testToken"))
 
-	go ret.FetchClusters(monitor, fetchClusterIDs, false, "323a00cd-428a-49fb-80ab-201d2a5d3050", fakeDynamicClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ret.FetchClusters(ctx, monitor, fetchClusterIDs, make(chan types.ProcessorData),
+		"323a00cd-428a-49fb-80ab-201d2a5d3050", fakeDynamicClient)
 	testData := <-fetchClusterIDs
 
 	assert.Equal(
@@ -114,6 +126,33 @@ func Test_FetchClusters(t *testing.T) {
 	)
 }
 
+func Test_waitForNextPoll_IntervalChanged(t *testing.T) {
+	monitor := monitor.NewClusterMonitor()
+	previous := monitor.GetPollInterval()
+	defer monitor.SetPollInterval(previous)
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	monitor.SetPollInterval(10 * time.Millisecond)
+	polled := make(chan bool)
+	go func() {
+		polled <- waitForNextPoll(context.Background(), monitor, ticker)
+	}()
+	select {
+	case ok := <-polled:
+		assert.True(t, ok, "Test next poll after the interval change")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ticker was not reset when the poll interval changed")
+	}
+}
+
+// needsCCX returns a Monitor.NeedsCCX reading the given map
+func needsCCX(clusterCCXMap map[string]bool) func(clusterID string) bool {
+	return func(clusterID string) bool {
+		return clusterCCXMap[clusterID]
+	}
+}
+
 func TestRetrieveReport(t *testing.T) {
 	t.Run("Successful report retrieval", func(t *testing.T) {
 		// Create a mock server
@@ -169,8 +208,10 @@ func TestRetrieveReport(t *testing.T) {
 			"34c3ecc5-624a-49a5-bab8-4fdc5e51a266": true,
 		}
 
-		ret := NewRetriever(ts.URL, nil, "testToken")
-		go ret.RetrieveReport("testHubID", input, output, clusterCCXMap, false)
+		ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go ret.RetrieveReport(ctx, "testHubID", input, output, needsCCX(clusterCCXMap), false)
 
 		result := <-output
 		if result.ClusterInfo.Namespace != cluster.Namespace {
@@ -183,5 +224,359 @@ func TestRetrieveReport(t *testing.T) {
 		if len(result.Report.Data) != 2 {
 			t.Errorf("Expected 2 reports to be retrieved, but got %d reports", len(result.Report.Data))
 		}
+		// The report is kept in the store for the API
+		entry, found := ret.Store.Get(cluster.Namespace)
+		assert.True(t, found, "Expected the report to be stored")
+		assert.Equal(t, 2, len(entry.Report.Data), "Expected the stored report to have 2 items")
+	})
+
+	t.Run("Cancelled context aborts the CCX request", func(t *testing.T) {
+		requestStarted := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(requestStarted)
+			<-r.Context().Done()
+		}))
+		defer ts.Close()
+
+		input := make(chan types.ManagedClusterInfo, 1)
+		output := make(chan types.ProcessorData, 1)
+		cluster := types.ManagedClusterInfo{Namespace: "slow-cluster", ClusterID: "b3ed8ed8-4a75-4a7f-9a6b-3f9f0d1c4b07"}
+		input <- cluster
+
+		ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			ret.RetrieveReport(ctx, "testHubID", input, output, needsCCX(map[string]bool{cluster.ClusterID: true}), false)
+			close(stopped)
+		}()
+
+		<-requestStarted
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("RetrieveReport did not return after the context was cancelled")
+		}
+		assert.Equal(t, 0, len(output), "Test aborted request is not sent to the processor")
+		_, found := ret.Store.Get(cluster.Namespace)
+		assert.False(t, found, "Test aborted request is not recorded as an error")
+	})
+
+	t.Run("Failed fetch sends the last report as stale", func(t *testing.T) {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests > 1 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": [{"rule_id": "test_rule_1"}]}}`)
+		}))
+		defer ts.Close()
+
+		input := make(chan types.ManagedClusterInfo)
+		output := make(chan types.ProcessorData)
+		cluster := types.ManagedClusterInfo{Namespace: "test-cluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+		ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go ret.RetrieveReport(ctx, "testHubID", input, output, needsCCX(map[string]bool{cluster.ClusterID: true}), false)
+
+		input <- cluster
+		fresh := <-output
+		assert.False(t, fresh.Stale, "Test received report is not stale")
+		assert.False(t, fresh.ReportTime.IsZero(), "Test received report time")
+
+		input <- cluster
+		stale := <-output
+		assert.True(t, stale.Stale, "Test report of a failed fetch is stale")
+		assert.Equal(t, 1, len(stale.Report.Data), "Test last report sent when the fetch fails")
+		entry, _ := ret.Store.Get(cluster.Namespace)
+		assert.Equal(t, entry.ReportTime, stale.ReportTime, "Test time of the last report sent")
+		assert.NotEqual(t, "", entry.LastError, "Test failed fetch recorded")
+	})
+}
+
+func TestRetrieveReport_NotModified(t *testing.T) {
+	lastModified := "Mon, 01 Jan 2024 00:00:00 GMT"
+	conditional := make(chan http.Header, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional <- r.Header.Clone()
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": [{"rule_id": "test_rule_1"}]}}`)
+	}))
+	defer ts.Close()
+
+	input := make(chan types.ManagedClusterInfo)
+	output := make(chan types.ProcessorData)
+	cluster := types.ManagedClusterInfo{Namespace: "test-cluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ret.RetrieveReport(ctx, "testHubID", input, output, needsCCX(map[string]bool{cluster.ClusterID: true}), false)
+
+	input <- cluster
+	<-output
+	header := <-conditional
+	assert.Equal(t, "", header.Get("If-None-Match"), "Test first request is not conditional")
+
+	input <- cluster
+	result := <-output
+	header = <-conditional
+	assert.Equal(t, `"v1"`, header.Get("If-None-Match"), "Test ETag sent back")
+	assert.Equal(t, lastModified, header.Get("If-Modified-Since"), "Test Last-Modified sent back")
+	assert.False(t, result.Stale, "Test not modified report is not stale")
+	assert.Equal(t, 1, len(result.Report.Data), "Test last report sent when not modified")
+	entry, _ := ret.Store.Get(cluster.Namespace)
+	assert.Equal(t, "", entry.LastError, "Test not modified is a successful fetch")
+
+	// A 304 without a report kept is a failed fetch
+	ret.Store.DropReport(cluster.Namespace)
+	req, _ := ret.CreateInsightsRequest(ctx, ts.URL, cluster, "testHubID")
+	req.Header.Set("If-None-Match", `"v1"`)
+	_, _, err := ret.CallInsights(req, cluster)
+	<-conditional
+	assert.NotNil(t, err, "Test not modified without a report")
+}
+
+func TestRetrieveReport_Workers(t *testing.T) {
+	t.Setenv("RETRIEVAL_WORKERS", "3")
+	t.Setenv("REQUEST_RATE", "100")
+	assert.Nil(t, config.SetupConfig())
+	t.Cleanup(func() { _ = config.SetupConfig() })
+
+	var inFlightLock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlightLock.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		inFlightLock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		inFlightLock.Lock()
+		inFlight--
+		inFlightLock.Unlock()
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": []}}`)
+	}))
+	defer ts.Close()
+
+	clusters := []types.ManagedClusterInfo{}
+	clusterCCXMap := map[string]bool{}
+	for i := 0; i < 9; i++ {
+		cluster := types.ManagedClusterInfo{Namespace: fmt.Sprintf("cluster-%d", i), ClusterID: fmt.Sprintf("id-%d", i)}
+		clusters = append(clusters, cluster)
+		clusterCCXMap[cluster.ClusterID] = true
+	}
+	input := make(chan types.ManagedClusterInfo)
+	output := make(chan types.ProcessorData)
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ret.RetrieveReport(ctx, "testHubID", input, output, needsCCX(clusterCCXMap), false)
+	go SendClusters(ctx, input, clusters)
+
+	for range clusters {
+		select {
+		case <-output:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Reports were not retrieved")
+		}
+	}
+	inFlightLock.Lock()
+	defer inFlightLock.Unlock()
+	assert.Equal(t, 3, maxInFlight, "Test clusters retrieved by RETRIEVAL_WORKERS workers")
+}
+
+func TestCallInsights_RequestRate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": []}}`)
+	}))
+	defer ts.Close()
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("testToken"))
+	ret.SetRequestRate(10)
+	cluster := types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		req, _ := ret.CreateInsightsRequest(context.TODO(), ts.URL, cluster, "testHubID")
+		_, _, err := ret.CallInsights(req, cluster)
+		assert.Nil(t, err)
+	}
+	// A burst of 10 requests, then one every 100ms
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond, "Test requests limited to REQUEST_RATE")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := ret.CreateInsightsRequest(ctx, ts.URL, cluster, "testHubID")
+	_, _, err := ret.CallInsights(req, cluster)
+	assert.NotNil(t, err, "Test cancelled request not waiting for the budget")
+}
+
+// countingProvider is a TokenProvider defined outside the credentials package
+type countingProvider struct {
+	invalidated int
+}
+
+func (p *countingProvider) Token(ctx context.Context) (string, error) {
+	return fmt.Sprintf("Bearer token-%d", p.invalidated), nil
+}
+
+func (p *countingProvider) Invalidate() {
+	p.invalidated++
+}
+
+func TestCallInsights_Unauthorized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": []}}`)
+	}))
+	defer ts.Close()
+
+	provider := &countingProvider{}
+	ret := NewRetriever(ts.URL, nil, provider)
+	cluster := types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+
+	req, err := ret.CreateInsightsRequest(context.TODO(), ts.URL, cluster, "hub")
+	assert.Nil(t, err)
+	_, _, err = ret.CallInsights(req, cluster)
+	assert.NotNil(t, err, "Test rejected token returns an error")
+	assert.Equal(t, 1, provider.invalidated, "Test rejected token invalidated")
+
+	req, err = ret.CreateInsightsRequest(context.TODO(), ts.URL, cluster, "hub")
+	assert.Nil(t, err)
+	_, _, err = ret.CallInsights(req, cluster)
+	assert.Nil(t, err, "Test next request uses the new token")
+}
+
+// A 401 for a token accepted for another cluster is an organization mismatch, the token is kept
+func TestCallInsights_OrgMismatch(t *testing.T) {
+	otherOrgID := "7b3a4dc4-0a2c-4b9d-8c1c-2b1d7f1f0e55"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, otherOrgID) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": []}}`)
+	}))
+	defer ts.Close()
+
+	provider := &countingProvider{}
+	ret := NewRetriever(ts.URL, nil, provider)
+	hubOrgCluster := types.ManagedClusterInfo{Namespace: "hubOrgCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+	otherOrgCluster := types.ManagedClusterInfo{Namespace: "otherOrgCluster", ClusterID: otherOrgID}
+
+	for _, cluster := range []types.ManagedClusterInfo{hubOrgCluster, otherOrgCluster, otherOrgCluster} {
+		req, err := ret.CreateInsightsRequest(context.TODO(), ts.URL, cluster, "hub")
+		assert.Nil(t, err)
+		_, _, _ = ret.CallInsights(req, cluster)
+	}
+	assert.Equal(t, 0, provider.invalidated, "Test accepted token not invalidated by an organization mismatch")
+}
+
+// A token rejected for every cluster is only invalidated once
+func TestCallInsights_InvalidatedOnce(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	provider := &countingProvider{}
+	ret := NewRetriever(ts.URL, nil, &fixedTokenProvider{countingProvider: provider})
+	for i := 0; i < 3; i++ {
+		cluster := types.ManagedClusterInfo{Namespace: fmt.Sprintf("cluster-%d", i), ClusterID: fmt.Sprintf("id-%d", i)}
+		req, err := ret.CreateInsightsRequest(context.TODO(), ts.URL, cluster, "hub")
+		assert.Nil(t, err)
+		_, _, _ = ret.CallInsights(req, cluster)
+	}
+	assert.Equal(t, 1, provider.invalidated, "Test the same token is invalidated once")
+}
+
+// fixedTokenProvider returns the same token after it is invalidated, like an unchanged pull-secret
+type fixedTokenProvider struct {
+	*countingProvider
+}
+
+func (p *fixedTokenProvider) Token(ctx context.Context) (string, error) {
+	return "Bearer revoked", nil
+}
+
+// Only a missing pull-secret token means the cluster is disconnected
+func TestNewRetriever_Disconnected(t *testing.T) {
+	ret := NewRetriever("https://ccx.example.com", nil, &failingProvider{})
+	assert.False(t, ret.DisconnectedEnv, "Test other providers errors are retried")
+}
+
+type failingProvider struct{}
+
+func (p *failingProvider) Token(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("token unavailable")
+}
+
+func (p *failingProvider) Invalidate() {}
+
+func TestCreateInsightsRequest_ClusterCredentials(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "org-b", Namespace: "open-cluster-management"},
+		Data:       map[string][]byte{credentials.TokenKey: []byte("Bearer org-b")},
 	})
+	ret := NewRetriever("https://ccx.example.com", nil, credentials.NewStaticProvider("Bearer hub"))
+	ret.Credentials = credentials.NewClusterCredentials(ret.Tokens, client, "open-cluster-management",
+		func(name string) (map[string]string, map[string]string) {
+			if name == "org-b-cluster" {
+				return nil, map[string]string{credentials.TokenSecretAnnotation: "org-b"}
+			}
+			return nil, nil
+		},
+	)
+
+	req, err := ret.CreateInsightsRequest(context.TODO(), "https://ccx.example.com",
+		types.ManagedClusterInfo{Namespace: "org-b-cluster", ClusterID: "7b3a4dc4-0a2c-4b9d-8c1c-2b1d7f1f0e55"}, "hub")
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer org-b", req.Header.Get("Authorization"), "Test cluster credentials used")
+
+	req, err = ret.CreateInsightsRequest(context.TODO(), "https://ccx.example.com",
+		types.ManagedClusterInfo{Namespace: "hub-org-cluster", ClusterID: "0f8d2e4b-6a1c-4e8b-9d3f-5c7a1b2e4d6f"}, "hub")
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer hub", req.Header.Get("Authorization"), "Test hub credentials used")
+
+	client.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("secrets is forbidden")
+	})
+	ret.Credentials = credentials.NewClusterCredentials(ret.Tokens, client, "open-cluster-management",
+		func(name string) (map[string]string, map[string]string) {
+			return nil, map[string]string{credentials.TokenSecretAnnotation: "org-b"}
+		},
+	)
+	_, err = ret.CreateInsightsRequest(context.TODO(), "https://ccx.example.com",
+		types.ManagedClusterInfo{Namespace: "org-b-cluster", ClusterID: "7b3a4dc4-0a2c-4b9d-8c1c-2b1d7f1f0e55"}, "hub")
+	assert.ErrorContains(t, err, "secret open-cluster-management/org-b", "Test cluster without usable credentials reported")
+}
+
+func TestSendClusters_Pending(t *testing.T) {
+	pending := metrics.PipelinePending.WithLabelValues(metrics.StageRetrieve)
+	before := testutil.ToFloat64(pending)
+	input := make(chan types.ManagedClusterInfo)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		done <- SendClusters(ctx, input, []types.ManagedClusterInfo{{Namespace: "cluster-1"}, {Namespace: "cluster-2"}})
+	}()
+
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(pending) == before+2 }, 5*time.Second, 10*time.Millisecond,
+		"Test clusters pending until a worker picks them up")
+	<-input
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(pending) == before+1 }, 5*time.Second, 10*time.Millisecond,
+		"Test cluster picked up")
+	cancel()
+	assert.False(t, <-done)
+	assert.Equal(t, before, testutil.ToFloat64(pending), "Test pending clusters cleared when cancelled")
 }
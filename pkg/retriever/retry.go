@@ -0,0 +1,146 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"github.com/stolostron/insights-client/pkg/types"
+)
+
+// Wait before the first retry, doubled for each retry until CCX_MAX_BACKOFF
+var retryBaseBackoff = time.Second
+
+// StatusError - CCX answered with an error status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("no Success HTTP Response code %d", e.StatusCode)
+}
+
+// send sends the request until CCX answers with a status code that isn't retried, or CCX_MAX_ATTEMPTS
+// attempts were made. 429, 5xx and network errors are retried after an exponential backoff with jitter
+// capped by CCX_MAX_BACKOFF, or after the Retry-After of the response. A Retry-After longer than
+// CCX_MAX_BACKOFF is not waited for, the next poll requests the report again.
+func (r *Retriever) send(req *http.Request, cluster types.ManagedClusterInfo) (*http.Response, error) {
+	cfg := config.Get()
+	maxAttempts := max(cfg.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		res, err := r.attempt(req, cfg)
+		if attempt >= maxAttempts || !isRetryable(res, err) || req.Context().Err() != nil {
+			return res, err
+		}
+		wait := backoff(attempt, cfg.MaxBackoff)
+		if after, ok := retryAfter(res); ok {
+			if after > cfg.MaxBackoff {
+				glog.Warningf("CCX asked to retry the request for cluster %s (%s) in %s, longer than CCX_MAX_BACKOFF",
+					cluster.Namespace, cluster.ClusterID, after)
+				return res, err
+			}
+			wait = after
+		}
+		glog.Warningf("CCX request for cluster %s (%s) failed: %s, retrying in %s (attempt %d of %d)",
+			cluster.Namespace, cluster.ClusterID, describeAttempt(res, err), wait, attempt+1, maxAttempts)
+		metrics.CCXRetries.WithLabelValues(metrics.StatusCodeLabel(statusCode(res))).Inc()
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt sends the request once the request budget allows it, unless the circuit breaker is open
+func (r *Retriever) attempt(req *http.Request, cfg config.Config) (*http.Response, error) {
+	if err := r.breaker.allow(cfg.BreakerCooldown); err != nil {
+		return nil, err
+	}
+	if err := r.limiter.Wait(req.Context()); err != nil {
+		r.breaker.cancel()
+		return nil, err
+	}
+	start := time.Now()
+	res, err := r.Client.Do(req)
+	code := metrics.StatusCodeLabel(statusCode(res))
+	metrics.CCXRequestDuration.WithLabelValues(code).Observe(time.Since(start).Seconds())
+	metrics.CCXRequests.WithLabelValues(code).Inc()
+	if req.Context().Err() != nil {
+		r.breaker.cancel()
+	} else {
+		r.breaker.record(isRetryable(res, err), cfg.BreakerFailures)
+	}
+	return res, err
+}
+
+func statusCode(res *http.Response) int {
+	if res == nil {
+		return 0
+	}
+	return res.StatusCode
+}
+
+// isRetryable returns true for network errors, 429 and 5xx. 400, 401, 404 and the other codes are final.
+func isRetryable(res *http.Response, err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+func describeAttempt(res *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return res.Status
+}
+
+// backoff returns the wait before the retry following the attempt: half of the exponential backoff,
+// plus a random part up to the other half so the workers don't retry together
+func backoff(attempt int, maxBackoff time.Duration) time.Duration {
+	wait := retryBaseBackoff
+	for i := 1; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, maxBackoff)
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// retryAfter returns the wait of the Retry-After header, given in seconds or as an HTTP date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(res.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,136 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/insights-client/pkg/config"
+	"github.com/stolostron/insights-client/pkg/credentials"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRetries(t *testing.T, maxAttempts string, maxBackoff string) {
+	t.Setenv("CCX_MAX_ATTEMPTS", maxAttempts)
+	t.Setenv("CCX_MAX_BACKOFF", maxBackoff)
+	assert.Nil(t, config.SetupConfig())
+	t.Cleanup(func() { _ = config.SetupConfig() })
+}
+
+// newRetryServer answers the status codes in order, then 200
+func newRetryServer(t *testing.T, header http.Header, codes ...int) (*httptest.Server, *int32) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n <= len(codes) {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(codes[n-1])
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"status": "ok", "report": {"data": []}}`)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+func callRetryServer(ts *httptest.Server) error {
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("Bearer token"))
+	cluster := types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+	req, _ := ret.CreateInsightsRequest(context.TODO(), ts.URL, cluster, "testHubID")
+	_, _, err := ret.CallInsights(req, cluster)
+	return err
+}
+
+func TestCallInsights_Retries(t *testing.T) {
+	setupRetries(t, "4", "10ms")
+
+	retriesBefore := testutil.ToFloat64(metrics.CCXRetries.WithLabelValues("503"))
+	ts, requests := newRetryServer(t, nil, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	assert.Nil(t, callRetryServer(ts), "Test 503 retried until the report is received")
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+	assert.Equal(t, retriesBefore+2, testutil.ToFloat64(metrics.CCXRetries.WithLabelValues("503")), "Test retries counter")
+
+	ts, requests = newRetryServer(t, nil, 500, 502, 504, 500)
+	err := callRetryServer(ts)
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr) && statusErr.StatusCode == 500, "Test last status code returned")
+	assert.Equal(t, int32(4), atomic.LoadInt32(requests), "Test CCX_MAX_ATTEMPTS attempts")
+
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		ts, requests = newRetryServer(t, nil, code)
+		assert.NotNil(t, callRetryServer(ts))
+		assert.Equal(t, int32(1), atomic.LoadInt32(requests), fmt.Sprintf("Test %d not retried", code))
+	}
+}
+
+func TestCallInsights_RetryAfter(t *testing.T) {
+	setupRetries(t, "2", "2s")
+
+	ts, requests := newRetryServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	start := time.Now()
+	assert.Nil(t, callRetryServer(ts), "Test 429 retried")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "Test Retry-After waited for")
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	ts, requests = newRetryServer(t, http.Header{"Retry-After": {"120"}}, http.StatusTooManyRequests)
+	assert.NotNil(t, callRetryServer(ts), "Test Retry-After longer than CCX_MAX_BACKOFF not waited for")
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestCallInsights_RetryCancelled(t *testing.T) {
+	setupRetries(t, "4", "1m")
+
+	ts, _ := newRetryServer(t, nil, 503, 503, 503)
+	ret := NewRetriever(ts.URL, nil, credentials.NewStaticProvider("Bearer token"))
+	cluster := types.ManagedClusterInfo{Namespace: "testCluster", ClusterID: "34c3ecc5-624a-49a5-bab8-4fdc5e51a266"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := ret.CreateInsightsRequest(ctx, ts.URL, cluster, "testHubID")
+	start := time.Now()
+	_, _, err := ret.CallInsights(req, cluster)
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "Test backoff interrupted by the context")
+}
+
+func Test_isRetryable(t *testing.T) {
+	assert.True(t, isRetryable(nil, errors.New("connection refused")), "Test network error retried")
+	for code, expected := range map[int]bool{200: false, 400: false, 401: false, 404: false, 429: true, 500: true, 503: true} {
+		assert.Equal(t, expected, isRetryable(&http.Response{StatusCode: code}, nil), fmt.Sprintf("Test %d", code))
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		wait := backoff(attempt, 5*time.Second)
+		assert.True(t, wait >= expected/2 && wait <= expected, fmt.Sprintf("Test attempt %d backoff %s", attempt, wait))
+	}
+	assert.Equal(t, time.Duration(0), backoff(1, 0))
+}
+
+func Test_retryAfter(t *testing.T) {
+	wait, ok := retryAfter(&http.Response{Header: http.Header{"Retry-After": {"30"}}})
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, wait, "Test Retry-After seconds")
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	wait, ok = retryAfter(&http.Response{Header: http.Header{"Retry-After": {date}}})
+	assert.True(t, ok)
+	assert.True(t, wait > 50*time.Second && wait <= time.Minute, "Test Retry-After date")
+
+	_, ok = retryAfter(&http.Response{Header: http.Header{"Retry-After": {"soon"}}})
+	assert.False(t, ok, "Test invalid Retry-After ignored")
+	_, ok = retryAfter(&http.Response{Header: http.Header{}})
+	assert.False(t, ok, "Test missing Retry-After")
+}
@@ -0,0 +1,87 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package retriever
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	knet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// transport sends the CCX requests with an http.Transport that is rebuilt when the trusted CAs or the proxy
+// change, the requests in progress finish with the previous one
+type transport struct {
+	lock    sync.RWMutex
+	rootCAs *x509.CertPool    // the system pool when nil
+	proxy   *httpproxy.Config // the proxy environment variables when nil
+	current *http.Transport
+}
+
+func newTransport() *transport {
+	t := &transport{}
+	t.current = t.build()
+	return t
+}
+
+// RoundTrip sends the request with the current http.Transport
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.RLock()
+	current := t.current
+	t.lock.RUnlock()
+	return current.RoundTrip(req)
+}
+
+// setRootCAs rebuilds the http.Transport trusting the pool
+func (t *transport) setRootCAs(rootCAs *x509.CertPool) {
+	t.rebuild(func() {
+		t.rootCAs = rootCAs
+	})
+}
+
+// setProxy rebuilds the http.Transport sending the requests through the proxy
+func (t *transport) setProxy(proxy *httpproxy.Config) {
+	t.rebuild(func() {
+		t.proxy = proxy
+	})
+}
+
+// rebuild applies the change with lock held and closes the idle connections of the previous http.Transport
+func (t *transport) rebuild(change func()) {
+	t.lock.Lock()
+	change()
+	previous := t.current
+	t.current = t.build()
+	t.lock.Unlock()
+	previous.CloseIdleConnections()
+}
+
+// build must be called with lock held
+func (t *transport) build() *http.Transport {
+	proxy := knet.NewProxierWithNoProxyCIDR(http.ProxyFromEnvironment)
+	if t.proxy != nil {
+		proxyURL := t.proxy.ProxyFunc()
+		proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyURL(req.URL)
+		}
+	}
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		DisableKeepAlives:   true,
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    t.rootCAs,
+		},
+	}
+}
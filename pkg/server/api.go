@@ -0,0 +1,372 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"github.com/stolostron/insights-client/pkg/store"
+	"github.com/stolostron/insights-client/pkg/types"
+)
+
+// Number of riskiest clusters returned by GET /api/v1/summary when top is not set
+const defaultSummaryTop = 10
+
+// Number of clusters whose access is checked at the same time
+const accessReviewConcurrency = 10
+
+// Number of refreshes waiting for their clusters above which new refreshes are rejected
+const maxPendingRefreshes = 100
+
+// ClusterLister is the part of the monitor.Monitor used by the API
+type ClusterLister interface {
+	GetManagedClusterInfo() []types.ManagedClusterInfo
+	NeedsCCX(clusterID string) bool
+}
+
+// ClusterStatus is the state of a monitored cluster returned by the API
+type ClusterStatus struct {
+	Name      string     `json:"name"`
+	ClusterID string     `json:"clusterID"`
+	NeedsCCX  bool       `json:"needsCCX"`
+	LastFetch *time.Time `json:"lastFetch,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+	// Credentials sent to CCX for the cluster, "default" for the hub credentials
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// ClusterList is the body returned by GET /api/v1/clusters
+type ClusterList struct {
+	Items []ClusterStatus `json:"items"`
+}
+
+// RuleList is the body returned by GET /api/v1/rules
+type RuleList struct {
+	Items []store.RuleSummary `json:"items"`
+}
+
+// ErrorResponse is the body returned when a request fails
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// API serves the JSON API for the monitored clusters and their reports
+type API struct {
+	ctx        context.Context // stops the refreshes waiting for the pipeline
+	monitor    ClusterLister
+	store      *store.ReportStore
+	queue      chan<- types.ManagedClusterInfo // input of the retrieval pipeline
+	authorizer Authorizer
+
+	// SelectsCluster returns false for the clusters not selected by the InsightsClientConfig,
+	// which are not refreshed. Every cluster is refreshed when nil.
+	SelectsCluster func(namespace string) bool
+	// CredentialsName returns the name of the credentials of the cluster, not reported when nil
+	CredentialsName func(namespace string) string
+
+	// Clusters waiting to be sent to the pipeline for a refresh, each cluster is queued once
+	// and completes every refresh requested until a worker picks it up
+	refreshLock   sync.Mutex
+	refreshQueue  []types.ManagedClusterInfo
+	refreshQueued map[string]bool
+	refreshReady  chan struct{}
+}
+
+// NewAPI ... The context stops sending the refreshed clusters to the pipeline.
+func NewAPI(
+	ctx context.Context,
+	monitor ClusterLister,
+	reports *store.ReportStore,
+	queue chan<- types.ManagedClusterInfo,
+	authorizer Authorizer,
+) *API {
+	api := &API{
+		ctx:           ctx,
+		monitor:       monitor,
+		store:         reports,
+		queue:         queue,
+		authorizer:    authorizer,
+		refreshQueued: map[string]bool{},
+		refreshReady:  make(chan struct{}, 1),
+	}
+	go api.sendRefreshes()
+	return api
+}
+
+// AddAPIRoutes registers the /api/v1 routes on the router and returns the API subrouter.
+// Every route requires a bearer token and only returns the clusters the caller can access.
+func AddAPIRoutes(router *mux.Router, api *API) *mux.Router {
+	apiRouter := router.PathPrefix("/api/v1").Subrouter()
+	apiRouter.Use(AuthMiddleware(api.authorizer))
+	apiRouter.HandleFunc("/clusters", api.ListClusters).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/clusters/{name}/report", api.GetClusterReport).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/clusters/{name}/refresh", api.RefreshCluster).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/refresh", api.RefreshAll).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/refresh/{id}", api.GetRefresh).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/rules", api.ListRules).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/rules/{rule_id}/clusters", api.GetRuleClusters).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/summary", api.GetSummary).Methods(http.MethodGet)
+	return apiRouter
+}
+
+// ListClusters returns every monitored cluster with its CCX eligibility and last fetch state
+func (a *API) ListClusters(w http.ResponseWriter, r *http.Request) {
+	list := ClusterList{Items: []ClusterStatus{}}
+	for _, cluster := range a.accessibleClusters(r) {
+		list.Items = append(list.Items, a.clusterStatus(cluster))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GetClusterReport returns the last report received for the cluster
+func (a *API) GetClusterReport(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, found := a.findCluster(r, name); !found {
+		writeError(w, http.StatusNotFound, "cluster "+name+" is not monitored")
+		return
+	}
+	entry, found := a.store.Get(name)
+	if !found || entry.Report == nil {
+		writeError(w, http.StatusNotFound, "no report received for cluster "+name)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry.Report)
+}
+
+// RefreshCluster sends the cluster to the retrieval pipeline without waiting for the poll interval
+func (a *API) RefreshCluster(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	cluster, found := a.findCluster(r, name)
+	if !found {
+		writeError(w, http.StatusNotFound, "cluster "+name+" is not monitored")
+		return
+	}
+	a.refresh(w, []types.ManagedClusterInfo{cluster})
+}
+
+// RefreshAll sends every monitored cluster to the retrieval pipeline without waiting for the poll interval
+func (a *API) RefreshAll(w http.ResponseWriter, r *http.Request) {
+	a.refresh(w, a.accessibleClusters(r))
+}
+
+// GetRefresh returns the progress of a refresh
+func (a *API) GetRefresh(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	refresh, found := a.store.GetRefresh(id)
+	if !found {
+		writeError(w, http.StatusNotFound, "refresh "+id+" not found")
+		return
+	}
+	// Only show the clusters the caller can access
+	allowed := a.clusterFilter(r)
+	clusters := refresh.Clusters
+	refresh.Clusters = filterNamespaces(refresh.Clusters, allowed)
+	refresh.Pending = filterNamespaces(refresh.Pending, allowed)
+	for namespace := range refresh.Skipped {
+		if !allowed(namespace) {
+			delete(refresh.Skipped, namespace)
+		}
+	}
+	if len(clusters) > 0 && len(refresh.Clusters) == 0 {
+		writeError(w, http.StatusNotFound, "refresh "+id+" not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, refresh)
+}
+
+// ListRules returns every Insights rule impacting at least one cluster
+func (a *API) ListRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, RuleList{Items: a.store.ListRules(a.clusterFilter(r))})
+}
+
+// GetRuleClusters returns the clusters impacted by an Insights rule
+func (a *API) GetRuleClusters(w http.ResponseWriter, r *http.Request) {
+	ruleID := mux.Vars(r)["rule_id"]
+	result, found := a.store.GetRuleClusters(ruleID, a.clusterFilter(r))
+	if !found {
+		writeError(w, http.StatusNotFound, "no cluster is impacted by rule "+ruleID)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// GetSummary returns the fleet-wide breakdown of the current results and the riskiest clusters
+func (a *API) GetSummary(w http.ResponseWriter, r *http.Request) {
+	top := defaultSummaryTop
+	if value := r.URL.Query().Get("top"); value != "" {
+		var err error
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 0 {
+			writeError(w, http.StatusBadRequest, "top must be a positive integer")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, a.store.Summary(top, a.clusterFilter(r)))
+}
+
+func (a *API) refresh(w http.ResponseWriter, clusters []types.ManagedClusterInfo) {
+	if a.store.PendingRefreshes() >= maxPendingRefreshes {
+		writeError(w, http.StatusTooManyRequests, "too many refreshes in progress, retry later")
+		return
+	}
+	selected := make([]types.ManagedClusterInfo, 0, len(clusters))
+	namespaces := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if a.SelectsCluster != nil && !a.SelectsCluster(cluster.Namespace) {
+			glog.V(2).Infof("Not refreshing cluster %s not selected by the InsightsClientConfig", cluster.Namespace)
+			continue
+		}
+		selected = append(selected, cluster)
+		namespaces = append(namespaces, cluster.Namespace)
+	}
+	refresh := a.store.StartRefresh(namespaces)
+	glog.Infof("Starting refresh %s for %d clusters", refresh.ID, len(selected))
+	a.queueRefresh(selected)
+	writeJSON(w, http.StatusAccepted, refresh)
+}
+
+// queueRefresh queues the clusters for sendRefreshes, skipping the clusters already queued
+func (a *API) queueRefresh(clusters []types.ManagedClusterInfo) {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+	pending := metrics.PipelinePending.WithLabelValues(metrics.StageRetrieve)
+	for _, cluster := range clusters {
+		if a.refreshQueued[cluster.Namespace] {
+			glog.V(2).Infof("Cluster %s is already queued for a refresh", cluster.Namespace)
+			continue
+		}
+		a.refreshQueued[cluster.Namespace] = true
+		a.refreshQueue = append(a.refreshQueue, cluster)
+		pending.Inc()
+	}
+	select {
+	case a.refreshReady <- struct{}{}:
+	default:
+	}
+}
+
+// sendRefreshes sends the queued clusters to the pipeline, paced by the workers like the poll,
+// until the context is cancelled
+func (a *API) sendRefreshes() {
+	pending := metrics.PipelinePending.WithLabelValues(metrics.StageRetrieve)
+	for {
+		a.refreshLock.Lock()
+		if len(a.refreshQueue) == 0 {
+			a.refreshLock.Unlock()
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-a.refreshReady:
+			}
+			continue
+		}
+		cluster := a.refreshQueue[0]
+		a.refreshLock.Unlock()
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case a.queue <- cluster:
+			pending.Dec()
+		}
+		a.refreshLock.Lock()
+		a.refreshQueue = a.refreshQueue[1:]
+		delete(a.refreshQueued, cluster.Namespace)
+		a.refreshLock.Unlock()
+	}
+}
+
+// findCluster returns the monitored cluster with the given name, if the caller can access it
+func (a *API) findCluster(r *http.Request, name string) (types.ManagedClusterInfo, bool) {
+	for _, cluster := range a.monitor.GetManagedClusterInfo() {
+		if cluster.Namespace == name {
+			user, ok := userFromContext(r.Context())
+			return cluster, ok && a.authorizer.CanAccessCluster(r.Context(), user, cluster.Namespace)
+		}
+	}
+	return types.ManagedClusterInfo{}, false
+}
+
+// accessibleClusters returns the monitored clusters the caller can access. Every cluster is returned when the caller
+// can access all of them, otherwise the clusters are checked concurrently.
+func (a *API) accessibleClusters(r *http.Request) []types.ManagedClusterInfo {
+	user, ok := userFromContext(r.Context())
+	clusters := []types.ManagedClusterInfo{}
+	if !ok {
+		return clusters
+	}
+	monitored := a.monitor.GetManagedClusterInfo()
+	if a.authorizer.CanAccessAllClusters(r.Context(), user) {
+		return append(clusters, monitored...)
+	}
+
+	allowed := make([]bool, len(monitored))
+	limit := make(chan struct{}, accessReviewConcurrency)
+	var wg sync.WaitGroup
+	for i, cluster := range monitored {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, namespace string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			allowed[i] = a.authorizer.CanAccessCluster(r.Context(), user, namespace)
+		}(i, cluster.Namespace)
+	}
+	wg.Wait()
+	for i, cluster := range monitored {
+		if allowed[i] {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// clusterFilter returns a filter allowing the clusters the caller can access.
+// The access is checked up front so the store is not locked during the SubjectAccessReviews.
+func (a *API) clusterFilter(r *http.Request) func(namespace string) bool {
+	allowed := map[string]bool{}
+	for _, cluster := range a.accessibleClusters(r) {
+		allowed[cluster.Namespace] = true
+	}
+	return func(namespace string) bool {
+		return allowed[namespace]
+	}
+}
+
+func filterNamespaces(namespaces []string, allowed func(namespace string) bool) []string {
+	filtered := []string{}
+	for _, namespace := range namespaces {
+		if allowed(namespace) {
+			filtered = append(filtered, namespace)
+		}
+	}
+	return filtered
+}
+
+func (a *API) clusterStatus(cluster types.ManagedClusterInfo) ClusterStatus {
+	status := ClusterStatus{
+		Name:      cluster.Namespace,
+		ClusterID: cluster.ClusterID,
+		NeedsCCX:  a.monitor.NeedsCCX(cluster.ClusterID),
+	}
+	if a.CredentialsName != nil {
+		status.Credentials = a.CredentialsName(cluster.Namespace)
+	}
+	if entry, found := a.store.Get(cluster.Namespace); found {
+		lastFetch := entry.LastFetch
+		status.LastFetch = &lastFetch
+		status.LastError = entry.LastError
+	}
+	return status
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, ErrorResponse{Message: message})
+}
@@ -0,0 +1,335 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stolostron/insights-client/pkg/store"
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+type fakeLister struct {
+	clusters []types.ManagedClusterInfo
+	needsCCX map[string]bool
+}
+
+func (f *fakeLister) GetManagedClusterInfo() []types.ManagedClusterInfo { return f.clusters }
+func (f *fakeLister) NeedsCCX(clusterID string) bool                    { return f.needsCCX[clusterID] }
+
+var (
+	localCluster   = types.ManagedClusterInfo{Namespace: "local-cluster", ClusterID: "58bd7441-812e-4fab-9aa6-eec452059c59"}
+	managedCluster = types.ManagedClusterInfo{Namespace: "managed-cluster", ClusterID: "323a00cd-428a-49fb-80ab-201d2a5d3050"}
+)
+
+// fakeAuthorizer accepts the "valid" token, the user can access every cluster unless denied
+type fakeAuthorizer struct {
+	denied map[string]bool
+}
+
+func (f *fakeAuthorizer) Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, error) {
+	if token != "valid" {
+		return authenticationv1.UserInfo{}, errors.New("token is not authenticated")
+	}
+	return authenticationv1.UserInfo{Username: "test-user"}, nil
+}
+
+func (f *fakeAuthorizer) CanAccessCluster(ctx context.Context, user authenticationv1.UserInfo, cluster string) bool {
+	return !f.denied[cluster]
+}
+
+func (f *fakeAuthorizer) CanAccessAllClusters(ctx context.Context, user authenticationv1.UserInfo) bool {
+	return len(f.denied) == 0
+}
+
+func (f *fakeAuthorizer) CanReadMetrics(ctx context.Context, user authenticationv1.UserInfo) bool {
+	return !f.denied["/metrics"]
+}
+
+func newTestAPI() (*API, *store.ReportStore) {
+	api, reports, _ := newTestAPIWithQueue()
+	return api, reports
+}
+
+func newTestAPIWithQueue() (*API, *store.ReportStore, chan types.ManagedClusterInfo) {
+	reports := store.NewReportStore()
+	queue := make(chan types.ManagedClusterInfo, 10)
+	lister := &fakeLister{
+		clusters: []types.ManagedClusterInfo{localCluster, managedCluster},
+		needsCCX: map[string]bool{localCluster.ClusterID: true},
+	}
+	return NewAPI(context.Background(), lister, reports, queue, &fakeAuthorizer{}), reports, queue
+}
+
+func doAPIRequest(t *testing.T, api *API, method string, path string, body interface{}) int {
+	return doAPIRequestWithToken(t, api, "valid", method, path, body)
+}
+
+func doAPIRequestWithToken(t *testing.T, api *API, token string, method string, path string, body interface{}) int {
+	router := mux.NewRouter()
+	AddAPIRoutes(router, api)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(rec, req)
+
+	if body != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), body); err != nil {
+			t.Fatalf("Unable to unmarshal response of %s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func Test_ListClusters(t *testing.T) {
+	api, reports := newTestAPI()
+	reports.SetReport(localCluster, types.ReportBody{}, store.Validators{})
+	reports.SetError(managedCluster, errors.New("no Success HTTP Response code "))
+
+	var list ClusterList
+	code := doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters", &list)
+
+	assert.Equal(t, http.StatusOK, code, "Test list clusters status code")
+	assert.Equal(t, 2, len(list.Items), "Test list clusters length")
+	assert.Equal(t, "local-cluster", list.Items[0].Name, "Test local-cluster name")
+	assert.True(t, list.Items[0].NeedsCCX, "Test local-cluster needs CCX")
+	assert.NotNil(t, list.Items[0].LastFetch, "Test local-cluster last fetch")
+	assert.False(t, list.Items[1].NeedsCCX, "Test managed-cluster does not need CCX")
+	assert.Equal(t, "no Success HTTP Response code ", list.Items[1].LastError, "Test managed-cluster last error")
+	assert.Empty(t, list.Items[0].Credentials, "Test credentials not reported without ClusterCredentials")
+
+	api.CredentialsName = func(namespace string) string { return "default" }
+	doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters", &list)
+	assert.Equal(t, "default", list.Items[1].Credentials, "Test cluster using the hub credentials reported")
+}
+
+func Test_GetClusterReport(t *testing.T) {
+	api, reports := newTestAPI()
+	reports.SetReport(localCluster, types.ReportBody{Data: []types.ReportData{{RuleID: "rule1"}}}, store.Validators{})
+
+	var report types.ReportBody
+	code := doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters/local-cluster/report", &report)
+
+	assert.Equal(t, http.StatusOK, code, "Test get report status code")
+	assert.Equal(t, "rule1", report.Data[0].RuleID, "Test get report content")
+}
+
+func Test_GetClusterReport_NotFound(t *testing.T) {
+	api, _ := newTestAPI()
+
+	var errResp ErrorResponse
+	code := doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters/managed-cluster/report", &errResp)
+	assert.Equal(t, http.StatusNotFound, code, "Test get report before any fetch")
+	assert.Equal(t, "no report received for cluster managed-cluster", errResp.Message, "Test error message")
+
+	code = doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters/unknown/report", &errResp)
+	assert.Equal(t, http.StatusNotFound, code, "Test get report of unknown cluster")
+}
+
+func Test_RefreshCluster(t *testing.T) {
+	api, reports, queue := newTestAPIWithQueue()
+
+	var refresh store.Refresh
+	code := doAPIRequest(t, api, http.MethodPost, "/api/v1/clusters/managed-cluster/refresh", &refresh)
+	assert.Equal(t, http.StatusAccepted, code, "Test refresh cluster status code")
+	assert.Equal(t, store.RefreshPending, refresh.Status, "Test refresh is pending")
+	assert.Equal(t, managedCluster, <-queue, "Test cluster is sent to the pipeline")
+
+	// The processor completes the refresh once the PolicyReport is written
+	reports.CompleteRefresh("managed-cluster", time.Now())
+	code = doAPIRequest(t, api, http.MethodGet, "/api/v1/refresh/"+refresh.ID, &refresh)
+	assert.Equal(t, http.StatusOK, code, "Test get refresh status code")
+	assert.Equal(t, store.RefreshCompleted, refresh.Status, "Test refresh is completed")
+}
+
+func Test_RefreshAll(t *testing.T) {
+	api, _, queue := newTestAPIWithQueue()
+
+	var refresh store.Refresh
+	code := doAPIRequest(t, api, http.MethodPost, "/api/v1/refresh", &refresh)
+	assert.Equal(t, http.StatusAccepted, code, "Test refresh all status code")
+	assert.Equal(t, []string{"local-cluster", "managed-cluster"}, refresh.Clusters, "Test refresh all clusters")
+	assert.Equal(t, localCluster, <-queue, "Test local-cluster is sent to the pipeline")
+	assert.Equal(t, managedCluster, <-queue, "Test managed-cluster is sent to the pipeline")
+}
+
+// Clusters not selected by the InsightsClientConfig are not refreshed
+func Test_RefreshAll_NotSelected(t *testing.T) {
+	api, _, queue := newTestAPIWithQueue()
+	api.SelectsCluster = func(name string) bool { return name != "local-cluster" }
+
+	var refresh store.Refresh
+	code := doAPIRequest(t, api, http.MethodPost, "/api/v1/refresh", &refresh)
+	assert.Equal(t, http.StatusAccepted, code, "Test refresh all status code")
+	assert.Equal(t, []string{"managed-cluster"}, refresh.Clusters, "Test only the selected clusters are refreshed")
+	assert.Equal(t, managedCluster, <-queue, "Test managed-cluster is sent to the pipeline")
+}
+
+// The refreshed clusters are no longer sent to the pipeline when the context is cancelled
+func Test_Refresh_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lister := &fakeLister{clusters: []types.ManagedClusterInfo{localCluster, managedCluster}}
+	api := NewAPI(ctx, lister, store.NewReportStore(), make(chan types.ManagedClusterInfo), &fakeAuthorizer{})
+	cancel()
+
+	code := doAPIRequest(t, api, http.MethodPost, "/api/v1/refresh", nil)
+	assert.Equal(t, http.StatusAccepted, code, "Test refresh is accepted")
+	api.sendRefreshes()
+}
+
+// A cluster waiting for the pipeline is not queued again by the next refreshes, it completes all of them
+func Test_Refresh_Coalesced(t *testing.T) {
+	reports := store.NewReportStore()
+	queue := make(chan types.ManagedClusterInfo)
+	lister := &fakeLister{clusters: []types.ManagedClusterInfo{localCluster, managedCluster}}
+	api := NewAPI(context.Background(), lister, reports, queue, &fakeAuthorizer{})
+
+	var first, second store.Refresh
+	doAPIRequest(t, api, http.MethodPost, "/api/v1/clusters/managed-cluster/refresh", &first)
+	doAPIRequest(t, api, http.MethodPost, "/api/v1/clusters/managed-cluster/refresh", &second)
+	assert.Equal(t, managedCluster, <-queue, "Test cluster is sent to the pipeline")
+	select {
+	case cluster := <-queue:
+		t.Errorf("Test cluster sent once, %s sent again", cluster.Namespace)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	reports.CompleteRefresh("managed-cluster", time.Now())
+	first, _ = reports.GetRefresh(first.ID)
+	second, _ = reports.GetRefresh(second.ID)
+	assert.Equal(t, store.RefreshCompleted, first.Status, "Test first refresh is completed")
+	assert.Equal(t, store.RefreshCompleted, second.Status, "Test second refresh is completed")
+}
+
+func Test_Refresh_TooMany(t *testing.T) {
+	api, reports, _ := newTestAPIWithQueue()
+	for i := 0; i < maxPendingRefreshes; i++ {
+		reports.StartRefresh([]string{"managed-cluster"})
+	}
+
+	code := doAPIRequest(t, api, http.MethodPost, "/api/v1/refresh", nil)
+	assert.Equal(t, http.StatusTooManyRequests, code, "Test refresh rejected while too many are pending")
+}
+
+func Test_Refresh_NotFound(t *testing.T) {
+	api, _ := newTestAPI()
+
+	code := doAPIRequest(t, api, http.MethodPost, "/api/v1/clusters/unknown/refresh", nil)
+	assert.Equal(t, http.StatusNotFound, code, "Test refresh of unknown cluster")
+
+	code = doAPIRequest(t, api, http.MethodGet, "/api/v1/refresh/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, code, "Test get unknown refresh")
+}
+
+func Test_Rules(t *testing.T) {
+	api, reports := newTestAPI()
+	rule := types.ReportData{
+		RuleID:    "master_defined_as_machinesets|MASTER_DEFINED_AS_MACHINESETS",
+		TotalRisk: 3,
+		ExtraData: types.ExtraData{ErrorKey: "MASTER_DEFINED_AS_MACHINESETS", Nodes: []string{"master-0"}},
+	}
+	reports.SetReport(localCluster, types.ReportBody{Data: []types.ReportData{rule}}, store.Validators{})
+	reports.SetReport(managedCluster, types.ReportBody{Data: []types.ReportData{rule}}, store.Validators{})
+
+	var list RuleList
+	code := doAPIRequest(t, api, http.MethodGet, "/api/v1/rules", &list)
+	assert.Equal(t, http.StatusOK, code, "Test list rules status code")
+	assert.Equal(t, 1, len(list.Items), "Test list rules length")
+	assert.Equal(t, 2, list.Items[0].ImpactedClusters, "Test list rules impacted clusters")
+
+	var ruleClusters store.RuleClusters
+	code = doAPIRequest(t, api, http.MethodGet,
+		"/api/v1/rules/master_defined_as_machinesets%7CMASTER_DEFINED_AS_MACHINESETS/clusters", &ruleClusters)
+	assert.Equal(t, http.StatusOK, code, "Test rule clusters status code")
+	assert.Equal(t, 3, ruleClusters.HighestTotalRisk, "Test rule clusters highest total risk")
+	assert.Equal(t, "local-cluster", ruleClusters.Clusters[0].Cluster, "Test rule clusters cluster")
+	assert.Equal(t, []string{"master-0"}, ruleClusters.Clusters[0].Nodes, "Test rule clusters nodes")
+
+	code = doAPIRequest(t, api, http.MethodGet, "/api/v1/rules/unknown/clusters", nil)
+	assert.Equal(t, http.StatusNotFound, code, "Test unknown rule")
+}
+
+func Test_GetSummary(t *testing.T) {
+	api, reports := newTestAPI()
+	reports.SetResults(localCluster, []v1beta1.PolicyReportResult{
+		{Policy: "rule1", Category: "incident", Source: "insights", Properties: map[string]string{"total_risk": "3"}},
+		{Policy: "default.policy1", Category: "CM Configuration Management", Source: "grc", Properties: map[string]string{"total_risk": "4"}},
+	})
+	reports.SetResults(managedCluster, []v1beta1.PolicyReportResult{
+		{Policy: "rule1", Category: "incident", Source: "insights", Properties: map[string]string{"total_risk": "3"}},
+	})
+
+	var summary store.FleetSummary
+	code := doAPIRequest(t, api, http.MethodGet, "/api/v1/summary?top=1", &summary)
+	assert.Equal(t, http.StatusOK, code, "Test summary status code")
+	assert.Equal(t, 3, summary.Violations, "Test summary violations")
+	assert.Equal(t, map[string]int{"3": 2, "4": 1}, summary.ByTotalRisk, "Test summary by total risk")
+	assert.Equal(t, map[string]int{"incident": 2, "CM Configuration Management": 1}, summary.ByCategory, "Test summary by category")
+	assert.Equal(t, map[string]int{"insights": 2, "grc": 1}, summary.BySource, "Test summary by source")
+	assert.Equal(t, []store.ClusterRisk{{
+		Cluster:          "local-cluster",
+		ClusterID:        localCluster.ClusterID,
+		Violations:       2,
+		HighestTotalRisk: 4,
+		RiskScore:        7,
+	}}, summary.RiskiestClusters, "Test summary riskiest clusters")
+
+	code = doAPIRequest(t, api, http.MethodGet, "/api/v1/summary?top=abc", nil)
+	assert.Equal(t, http.StatusBadRequest, code, "Test summary with invalid top")
+}
+
+func Test_API_Unauthorized(t *testing.T) {
+	api, _ := newTestAPI()
+
+	code := doAPIRequestWithToken(t, api, "", http.MethodGet, "/api/v1/clusters", nil)
+	assert.Equal(t, http.StatusUnauthorized, code, "Test request without token")
+
+	code = doAPIRequestWithToken(t, api, "invalid", http.MethodGet, "/api/v1/clusters", nil)
+	assert.Equal(t, http.StatusUnauthorized, code, "Test request with invalid token")
+}
+
+// Clusters the caller cannot access are never returned
+func Test_API_FiltersClusters(t *testing.T) {
+	api, reports, _ := newTestAPIWithQueue()
+	api.authorizer = &fakeAuthorizer{denied: map[string]bool{"managed-cluster": true}}
+	rule := types.ReportData{RuleID: "rule1", TotalRisk: 3}
+	reports.SetReport(managedCluster, types.ReportBody{Data: []types.ReportData{rule}}, store.Validators{})
+	reports.SetResults(managedCluster, []v1beta1.PolicyReportResult{
+		{Policy: "rule1", Source: "insights", Properties: map[string]string{"total_risk": "3"}},
+	})
+
+	var list ClusterList
+	doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters", &list)
+	assert.Equal(t, 1, len(list.Items), "Test denied cluster is not listed")
+	assert.Equal(t, "local-cluster", list.Items[0].Name, "Test allowed cluster is listed")
+
+	code := doAPIRequest(t, api, http.MethodGet, "/api/v1/clusters/managed-cluster/report", nil)
+	assert.Equal(t, http.StatusNotFound, code, "Test report of denied cluster")
+
+	code = doAPIRequest(t, api, http.MethodPost, "/api/v1/clusters/managed-cluster/refresh", nil)
+	assert.Equal(t, http.StatusNotFound, code, "Test refresh of denied cluster")
+
+	var rules RuleList
+	doAPIRequest(t, api, http.MethodGet, "/api/v1/rules", &rules)
+	assert.Equal(t, 0, len(rules.Items), "Test rules of denied cluster are not listed")
+
+	var summary store.FleetSummary
+	doAPIRequest(t, api, http.MethodGet, "/api/v1/summary", &summary)
+	assert.Equal(t, 0, summary.Violations, "Test results of denied cluster are not counted")
+
+	refresh := reports.StartRefresh([]string{"managed-cluster"})
+	code = doAPIRequest(t, api, http.MethodGet, "/api/v1/refresh/"+refresh.ID, nil)
+	assert.Equal(t, http.StatusNotFound, code, "Test refresh of denied cluster is hidden")
+}
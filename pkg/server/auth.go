@@ -0,0 +1,247 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	e "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// How long TokenReview and SubjectAccessReview results are cached
+const authCacheTTL = time.Minute
+
+type userContextKey struct{}
+
+// Authorizer authenticates the API callers and checks their access to the managed clusters
+type Authorizer interface {
+	Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, error)
+	CanAccessCluster(ctx context.Context, user authenticationv1.UserInfo, cluster string) bool
+	// CanAccessAllClusters is true when the user can access every cluster without checking them one by one
+	CanAccessAllClusters(ctx context.Context, user authenticationv1.UserInfo) bool
+	// CanReadMetrics is true when the user can get /metrics, which has the violations of every cluster
+	CanReadMetrics(ctx context.Context, user authenticationv1.UserInfo) bool
+}
+
+// KubeAuthorizer validates bearer tokens with a TokenReview and checks access to the managed clusters
+// with SubjectAccessReviews. A user can access a cluster if they can get its ManagedCluster and
+// list the PolicyReports in the managed cluster namespace.
+type KubeAuthorizer struct {
+	client    kubernetes.Interface
+	lock      sync.Mutex
+	users     map[string]cachedUser   // keyed by token hash
+	access    map[string]cachedAccess // keyed by user and cluster
+	lastPrune time.Time
+}
+
+type cachedUser struct {
+	user    authenticationv1.UserInfo
+	expires time.Time
+}
+
+type cachedAccess struct {
+	allowed bool
+	expires time.Time
+}
+
+// NewKubeAuthorizer ...
+func NewKubeAuthorizer(client kubernetes.Interface) *KubeAuthorizer {
+	return &KubeAuthorizer{
+		client: client,
+		users:  map[string]cachedUser{},
+		access: map[string]cachedAccess{},
+	}
+}
+
+// Authenticate validates the token with a TokenReview and returns the user it belongs to
+func (k *KubeAuthorizer) Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, error) {
+	hash := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(hash[:])
+	k.lock.Lock()
+	cached, ok := k.users[key]
+	k.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	review, err := k.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, fmt.Errorf("could not review token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, e.New("token is not authenticated")
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.pruneCache()
+	k.users[key] = cachedUser{user: review.Status.User, expires: time.Now().Add(authCacheTTL)}
+	return review.Status.User, nil
+}
+
+// CanAccessCluster checks the user can read the ManagedCluster and the PolicyReports in its namespace
+func (k *KubeAuthorizer) CanAccessCluster(ctx context.Context, user authenticationv1.UserInfo, cluster string) bool {
+	return k.cachedAccess(user, "cluster/"+cluster, func() bool {
+		return k.allowed(ctx, user, &authorizationv1.ResourceAttributes{
+			Verb:     "get",
+			Group:    "cluster.open-cluster-management.io",
+			Resource: "managedclusters",
+			Name:     cluster,
+		}, nil) && k.allowed(ctx, user, &authorizationv1.ResourceAttributes{
+			Verb:      "list",
+			Group:     "wgpolicyk8s.io",
+			Resource:  "policyreports",
+			Namespace: cluster,
+		}, nil)
+	})
+}
+
+// CanAccessAllClusters checks the user can read every ManagedCluster and the PolicyReports in every namespace
+func (k *KubeAuthorizer) CanAccessAllClusters(ctx context.Context, user authenticationv1.UserInfo) bool {
+	return k.cachedAccess(user, "all-clusters", func() bool {
+		return k.allowed(ctx, user, &authorizationv1.ResourceAttributes{
+			Verb:     "get",
+			Group:    "cluster.open-cluster-management.io",
+			Resource: "managedclusters",
+		}, nil) && k.allowed(ctx, user, &authorizationv1.ResourceAttributes{
+			Verb:     "list",
+			Group:    "wgpolicyk8s.io",
+			Resource: "policyreports",
+		}, nil)
+	})
+}
+
+// CanReadMetrics checks the user can get the /metrics non-resource URL, e.g. with the cluster-monitoring-view role
+func (k *KubeAuthorizer) CanReadMetrics(ctx context.Context, user authenticationv1.UserInfo) bool {
+	return k.cachedAccess(user, "metrics", func() bool {
+		return k.allowed(ctx, user, nil, &authorizationv1.NonResourceAttributes{Verb: "get", Path: "/metrics"})
+	})
+}
+
+// cachedAccess returns the cached result of the check for the user, or runs the check and caches its result
+func (k *KubeAuthorizer) cachedAccess(user authenticationv1.UserInfo, check string, allowed func() bool) bool {
+	key := user.UID + "/" + user.Username + "/" + check
+	k.lock.Lock()
+	cached, ok := k.access[key]
+	k.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.allowed
+	}
+
+	result := allowed()
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.pruneCache()
+	k.access[key] = cachedAccess{allowed: result, expires: time.Now().Add(authCacheTTL)}
+	return result
+}
+
+// pruneCache forgets the expired users and access results at most once per authCacheTTL,
+// must be called with lock held
+func (k *KubeAuthorizer) pruneCache() {
+	now := time.Now()
+	if now.Sub(k.lastPrune) < authCacheTTL {
+		return
+	}
+	k.lastPrune = now
+	for key, cached := range k.users {
+		if now.After(cached.expires) {
+			delete(k.users, key)
+		}
+	}
+	for key, cached := range k.access {
+		if now.After(cached.expires) {
+			delete(k.access, key)
+		}
+	}
+}
+
+func (k *KubeAuthorizer) allowed(
+	ctx context.Context,
+	user authenticationv1.UserInfo,
+	attributes *authorizationv1.ResourceAttributes,
+	nonResource *authorizationv1.NonResourceAttributes,
+) bool {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review, err := k.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes:    attributes,
+			NonResourceAttributes: nonResource,
+			User:                  user.Username,
+			Groups:                user.Groups,
+			UID:                   user.UID,
+			Extra:                 extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		var target string
+		if attributes != nil {
+			target = attributes.Resource + " " + attributes.Name + attributes.Namespace
+		} else {
+			target = nonResource.Path
+		}
+		glog.Warningf("Error checking access of %s to %s: %v", user.Username, target, err)
+		return false
+	}
+	return review.Status.Allowed
+}
+
+// AuthMiddleware rejects the requests without a valid bearer token and stores the caller in the request context
+func AuthMiddleware(authorizer Authorizer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || strings.TrimSpace(token) == "" {
+				writeError(w, http.StatusUnauthorized, "missing bearer token")
+				return
+			}
+			user, err := authorizer.Authenticate(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				glog.V(2).Infof("Rejecting API request: %v", err)
+				writeError(w, http.StatusUnauthorized, "invalid bearer token")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+		})
+	}
+}
+
+// MetricsAuthMiddleware only serves the callers with a valid bearer token who can get /metrics
+func MetricsAuthMiddleware(authorizer Authorizer) mux.MiddlewareFunc {
+	authenticate := AuthMiddleware(authorizer)
+	return func(next http.Handler) http.Handler {
+		return authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := userFromContext(r.Context())
+			if !authorizer.CanReadMetrics(r.Context(), user) {
+				writeError(w, http.StatusForbidden, "get /metrics is not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// userFromContext returns the caller stored by AuthMiddleware
+func userFromContext(ctx context.Context) (authenticationv1.UserInfo, bool) {
+	user, ok := ctx.Value(userContextKey{}).(authenticationv1.UserInfo)
+	return user, ok
+}
@@ -0,0 +1,152 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeKubeClient authenticates the "valid" token and allows test-user to access managed-cluster,
+// admin-user to access every cluster and prometheus to get /metrics
+func newFakeKubeClient(reviews *int) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		*reviews++
+		if review.Spec.Token == "valid" {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "test-user", UID: "1234"},
+			}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		*reviews++
+		attributes := review.Spec.ResourceAttributes
+		if attributes == nil {
+			review.Status.Allowed = review.Spec.User == "prometheus" && review.Spec.NonResourceAttributes.Path == "/metrics"
+			return true, review, nil
+		}
+		review.Status.Allowed = review.Spec.User == "admin-user" || (review.Spec.User == "test-user" &&
+			(attributes.Name == "managed-cluster" || attributes.Namespace == "managed-cluster"))
+		return true, review, nil
+	})
+	return client
+}
+
+func Test_KubeAuthorizer_Authenticate(t *testing.T) {
+	reviews := 0
+	authorizer := NewKubeAuthorizer(newFakeKubeClient(&reviews))
+
+	user, err := authorizer.Authenticate(context.TODO(), "valid")
+	assert.Nil(t, err, "Test valid token")
+	assert.Equal(t, "test-user", user.Username, "Test user of valid token")
+
+	_, err = authorizer.Authenticate(context.TODO(), "valid")
+	assert.Nil(t, err, "Test cached token")
+	assert.Equal(t, 1, reviews, "Test TokenReview result is cached")
+
+	_, err = authorizer.Authenticate(context.TODO(), "invalid")
+	assert.NotNil(t, err, "Test invalid token")
+}
+
+func Test_KubeAuthorizer_CanAccessCluster(t *testing.T) {
+	reviews := 0
+	authorizer := NewKubeAuthorizer(newFakeKubeClient(&reviews))
+	user := authenticationv1.UserInfo{Username: "test-user", UID: "1234"}
+
+	assert.True(t, authorizer.CanAccessCluster(context.TODO(), user, "managed-cluster"), "Test allowed cluster")
+	assert.Equal(t, 2, reviews, "Test ManagedCluster and namespace access are checked")
+	assert.True(t, authorizer.CanAccessCluster(context.TODO(), user, "managed-cluster"), "Test cached access")
+	assert.Equal(t, 2, reviews, "Test SubjectAccessReview result is cached")
+
+	assert.False(t, authorizer.CanAccessCluster(context.TODO(), user, "local-cluster"), "Test denied cluster")
+	assert.False(t, authorizer.CanAccessCluster(context.TODO(),
+		authenticationv1.UserInfo{Username: "other-user"}, "managed-cluster"), "Test denied user")
+}
+
+func Test_KubeAuthorizer_CanAccessAllClusters(t *testing.T) {
+	reviews := 0
+	authorizer := NewKubeAuthorizer(newFakeKubeClient(&reviews))
+
+	assert.True(t, authorizer.CanAccessAllClusters(context.TODO(), authenticationv1.UserInfo{Username: "admin-user"}),
+		"Test cluster-wide access")
+	assert.Equal(t, 2, reviews, "Test cluster-wide ManagedCluster and PolicyReport access are checked")
+	assert.False(t, authorizer.CanAccessAllClusters(context.TODO(), authenticationv1.UserInfo{Username: "test-user"}),
+		"Test access to a single cluster is not cluster-wide")
+}
+
+func Test_KubeAuthorizer_CanReadMetrics(t *testing.T) {
+	reviews := 0
+	authorizer := NewKubeAuthorizer(newFakeKubeClient(&reviews))
+
+	assert.True(t, authorizer.CanReadMetrics(context.TODO(), authenticationv1.UserInfo{Username: "prometheus"}),
+		"Test allowed metrics reader")
+	assert.False(t, authorizer.CanReadMetrics(context.TODO(), authenticationv1.UserInfo{Username: "test-user"}),
+		"Test denied metrics reader")
+}
+
+// Expired entries are forgotten instead of being kept for every token and cluster
+func Test_KubeAuthorizer_PruneCache(t *testing.T) {
+	reviews := 0
+	authorizer := NewKubeAuthorizer(newFakeKubeClient(&reviews))
+	user := authenticationv1.UserInfo{Username: "test-user", UID: "1234"}
+	_, _ = authorizer.Authenticate(context.TODO(), "valid")
+	authorizer.CanAccessCluster(context.TODO(), user, "managed-cluster")
+
+	expired := time.Now().Add(-time.Second)
+	for key, cached := range authorizer.users {
+		cached.expires = expired
+		authorizer.users[key] = cached
+	}
+	for key, cached := range authorizer.access {
+		cached.expires = expired
+		authorizer.access[key] = cached
+	}
+	authorizer.lastPrune = time.Time{}
+	authorizer.CanAccessCluster(context.TODO(), user, "local-cluster")
+
+	assert.Equal(t, 0, len(authorizer.users), "Test expired users are pruned")
+	assert.Equal(t, 1, len(authorizer.access), "Test only the new access result is kept")
+}
+
+func Test_MetricsAuthMiddleware(t *testing.T) {
+	authorizer := &fakeAuthorizer{denied: map[string]bool{"/metrics": true}}
+	router := mux.NewRouter()
+	router.Handle("/metrics", MetricsAuthMiddleware(authorizer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for _, test := range []struct {
+		token    string
+		denied   bool
+		expected int
+	}{
+		{token: "", expected: http.StatusUnauthorized},
+		{token: "valid", denied: true, expected: http.StatusForbidden},
+		{token: "valid", expected: http.StatusOK},
+	} {
+		authorizer.denied["/metrics"] = test.denied
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, test.expected, rec.Code, "Test /metrics access with token %q", test.token)
+	}
+}
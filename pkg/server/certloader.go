@@ -0,0 +1,103 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/stolostron/insights-client/pkg/metrics"
+)
+
+// How often the certificate files are checked for changes
+const certCheckInterval = 30 * time.Second
+
+// CertLoader serves the certificate from the given files and swaps it when the files change,
+// for example when the service-ca operator or cert-manager rotates the secret.
+type CertLoader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	certPEM  []byte
+	keyPEM   []byte
+}
+
+// NewCertLoader loads the certificate, returns an error if it can't be loaded
+func NewCertLoader(certFile string, keyFile string) (*CertLoader, error) {
+	c := &CertLoader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, to be used as tls.Config.GetCertificate
+func (c *CertLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// Watch periodically reloads the certificate when the files change, until the context is cancelled
+func (c *CertLoader) Watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(certCheckInterval):
+		}
+		if _, err := c.reload(); err != nil {
+			glog.Errorf("Error reloading serving certificate, keeping the current one: %v", err)
+		}
+	}
+}
+
+// reload loads the certificate if the files changed, returns true if the certificate was swapped
+func (c *CertLoader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(c.certFile)
+	if err != nil {
+		return false, fmt.Errorf("could not read certificate %s: %v", c.certFile, err)
+	}
+	keyPEM, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("could not read key %s: %v", c.keyFile, err)
+	}
+
+	c.lock.RLock()
+	unchanged := bytes.Equal(certPEM, c.certPEM) && bytes.Equal(keyPEM, c.keyPEM)
+	c.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("could not load key pair: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("could not parse certificate: %v", err)
+	}
+	cert.Leaf = leaf
+
+	c.lock.Lock()
+	c.cert = &cert
+	c.certPEM = certPEM
+	c.keyPEM = keyPEM
+	c.lock.Unlock()
+
+	glog.Infof("Loaded serving certificate %s, expires %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	metrics.ServingCertExpiry.Set(float64(leaf.NotAfter.Unix()))
+	return true, nil
+}
@@ -0,0 +1,86 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/insights-client/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestCert(t *testing.T, dir string, commonName string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func Test_CertLoader(t *testing.T) {
+	dir := t.TempDir()
+	firstExpiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	certFile, keyFile := writeTestCert(t, dir, "first", firstExpiry)
+
+	loader, err := NewCertLoader(certFile, keyFile)
+	assert.Nil(t, err, "Test certificate loaded")
+	cert, _ := loader.GetCertificate(nil)
+	assert.Equal(t, "first", cert.Leaf.Subject.CommonName, "Test initial certificate served")
+	assert.Equal(t, float64(firstExpiry.Unix()), testutil.ToFloat64(metrics.ServingCertExpiry), "Test expiry exported")
+
+	swapped, err := loader.reload()
+	assert.Nil(t, err)
+	assert.False(t, swapped, "Test unchanged files are not reloaded")
+
+	secondExpiry := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	writeTestCert(t, dir, "second", secondExpiry)
+	swapped, err = loader.reload()
+	assert.Nil(t, err)
+	assert.True(t, swapped, "Test rotated certificate reloaded")
+	cert, _ = loader.GetCertificate(nil)
+	assert.Equal(t, "second", cert.Leaf.Subject.CommonName, "Test rotated certificate served")
+	assert.Equal(t, float64(secondExpiry.Unix()), testutil.ToFloat64(metrics.ServingCertExpiry), "Test new expiry exported")
+}
+
+func Test_CertLoader_InvalidKeepsCurrent(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "current", time.Now().Add(time.Hour))
+	loader, err := NewCertLoader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	// A half-written secret where the key doesn't match the certificate yet
+	assert.Nil(t, os.WriteFile(keyFile, []byte("not a key"), 0600))
+	_, err = loader.reload()
+	assert.NotNil(t, err, "Test invalid key pair rejected")
+	cert, _ := loader.GetCertificate(nil)
+	assert.Equal(t, "current", cert.Leaf.Subject.CommonName, "Test current certificate kept")
+}
+
+func Test_NewCertLoader_Missing(t *testing.T) {
+	_, err := NewCertLoader(filepath.Join(t.TempDir(), "tls.crt"), filepath.Join(t.TempDir(), "tls.key"))
+	assert.NotNil(t, err, "Test missing certificate returns an error")
+}
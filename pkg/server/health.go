@@ -0,0 +1,138 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/stolostron/insights-client/pkg/retriever"
+)
+
+const (
+	statusOK      = "ok"
+	statusPending = "pending"
+	statusFailed  = "unavailable"
+)
+
+// ClusterMonitor is the part of the monitor.Monitor used by the health checks
+type ClusterMonitor interface {
+	GetLocalCluster() string
+	IsInformerRunning() bool
+}
+
+// ReportRetriever is the part of the retriever.Retriever used by the health checks
+type ReportRetriever interface {
+	LastSuccessfulCall() time.Time
+	CircuitState() string
+}
+
+// CheckResult is the state of a single stage of the pipeline
+type CheckResult struct {
+	Status   string     `json:"status"`
+	Message  string     `json:"message,omitempty"`
+	LastTime *time.Time `json:"lastTime,omitempty"`
+}
+
+// HealthResponse is the JSON body returned by /healthz and /readyz
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	monitor   ClusterMonitor
+	retriever ReportRetriever
+}
+
+// NewHealthHandler ...
+func NewHealthHandler(monitor ClusterMonitor, retriever ReportRetriever) *HealthHandler {
+	return &HealthHandler{
+		monitor:   monitor,
+		retriever: retriever,
+	}
+}
+
+// AddHealthRoutes registers /healthz and /readyz on the router
+func AddHealthRoutes(router *mux.Router, h *HealthHandler) {
+	router.HandleFunc("/healthz", h.Liveness).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", h.Readiness).Methods(http.MethodGet)
+}
+
+// Liveness reports that the server is able to handle requests
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{
+		Status: statusOK,
+		Checks: map[string]CheckResult{"server": {Status: statusOK}},
+	})
+}
+
+// Readiness reports the state of each stage of the retrieve/process pipeline.
+// The client is ready once the hub ID is resolved and the ManagedCluster informer is running.
+// The CCX check is informational only, since disconnected hubs never call CCX.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]CheckResult{
+		"hubID":           h.checkHubID(),
+		"clusterInformer": h.checkInformer(),
+		"ccx":             h.checkCCX(),
+	}
+	status := statusOK
+	if checks["hubID"].Status != statusOK || checks["clusterInformer"].Status != statusOK {
+		status = statusFailed
+	}
+	writeHealth(w, HealthResponse{Status: status, Checks: checks})
+}
+
+func (h *HealthHandler) checkHubID() CheckResult {
+	if hubID := h.monitor.GetLocalCluster(); hubID != "" {
+		return CheckResult{Status: statusOK, Message: hubID}
+	}
+	return CheckResult{Status: statusPending, Message: "waiting for local-cluster id"}
+}
+
+func (h *HealthHandler) checkInformer() CheckResult {
+	if h.monitor.IsInformerRunning() {
+		return CheckResult{Status: statusOK}
+	}
+	return CheckResult{Status: statusPending, Message: "ManagedCluster informer is not running"}
+}
+
+func (h *HealthHandler) checkCCX() CheckResult {
+	lastCall := h.retriever.LastSuccessfulCall()
+	var lastTime *time.Time
+	if !lastCall.IsZero() {
+		lastTime = &lastCall
+	}
+	switch h.retriever.CircuitState() {
+	case retriever.CircuitOpen:
+		return CheckResult{Status: statusFailed, LastTime: lastTime,
+			Message: "circuit breaker open after repeated CCX failures, CCX is called again after the cooldown"}
+	case retriever.CircuitHalfOpen:
+		return CheckResult{Status: statusPending, LastTime: lastTime,
+			Message: "circuit breaker half-open, probing CCX"}
+	}
+	if lastCall.IsZero() {
+		return CheckResult{Status: statusPending, Message: "no successful CCX call yet"}
+	}
+	return CheckResult{Status: statusOK, LastTime: &lastCall}
+}
+
+func writeHealth(w http.ResponseWriter, resp HealthResponse) {
+	code := http.StatusOK
+	if resp.Status != statusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		glog.Warningf("Error encoding response body: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stolostron/insights-client/pkg/retriever"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMonitor struct {
+	hubID           string
+	informerRunning bool
+}
+
+func (f *fakeMonitor) GetLocalCluster() string { return f.hubID }
+func (f *fakeMonitor) IsInformerRunning() bool { return f.informerRunning }
+
+type fakeRetriever struct {
+	lastCall time.Time
+	circuit  string
+}
+
+func (f *fakeRetriever) LastSuccessfulCall() time.Time { return f.lastCall }
+
+func (f *fakeRetriever) CircuitState() string { return f.circuit }
+
+func doHealthRequest(t *testing.T, h *HealthHandler, path string) (int, HealthResponse) {
+	router := mux.NewRouter()
+	AddHealthRoutes(router, h)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var resp HealthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unable to unmarshal health response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func Test_Liveness(t *testing.T) {
+	h := NewHealthHandler(&fakeMonitor{}, &fakeRetriever{})
+	code, resp := doHealthRequest(t, h, "/healthz")
+
+	assert.Equal(t, http.StatusOK, code, "Test liveness status code")
+	assert.Equal(t, statusOK, resp.Status, "Test liveness status")
+}
+
+func Test_Readiness_NotReady(t *testing.T) {
+	h := NewHealthHandler(&fakeMonitor{}, &fakeRetriever{})
+	code, resp := doHealthRequest(t, h, "/readyz")
+
+	assert.Equal(t, http.StatusServiceUnavailable, code, "Test readiness status code before hub ID is resolved")
+	assert.Equal(t, statusPending, resp.Checks["hubID"].Status, "Test hubID check")
+	assert.Equal(t, statusPending, resp.Checks["clusterInformer"].Status, "Test clusterInformer check")
+	assert.Equal(t, statusPending, resp.Checks["ccx"].Status, "Test ccx check")
+}
+
+func Test_Readiness_Ready(t *testing.T) {
+	lastCall := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := NewHealthHandler(
+		&fakeMonitor{hubID: "58bd7441-812e-4fab-9aa6-eec452059c59", informerRunning: true},
+		&fakeRetriever{lastCall: lastCall},
+	)
+	code, resp := doHealthRequest(t, h, "/readyz")
+
+	assert.Equal(t, http.StatusOK, code, "Test readiness status code")
+	assert.Equal(t, statusOK, resp.Status, "Test readiness status")
+	assert.Equal(t, "58bd7441-812e-4fab-9aa6-eec452059c59", resp.Checks["hubID"].Message, "Test hubID check")
+	assert.Equal(t, lastCall, *resp.Checks["ccx"].LastTime, "Test ccx last successful call")
+}
+
+// CCX is informational - a disconnected hub is still ready
+func Test_Readiness_NoCCX(t *testing.T) {
+	h := NewHealthHandler(&fakeMonitor{hubID: "hub", informerRunning: true}, &fakeRetriever{})
+	code, resp := doHealthRequest(t, h, "/readyz")
+
+	assert.Equal(t, http.StatusOK, code, "Test readiness without CCX call")
+	assert.Equal(t, statusPending, resp.Checks["ccx"].Status, "Test ccx check")
+}
+
+// An open circuit breaker is reported, the hub is still ready
+func Test_Readiness_CircuitOpen(t *testing.T) {
+	lastCall := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := NewHealthHandler(&fakeMonitor{hubID: "hub", informerRunning: true},
+		&fakeRetriever{lastCall: lastCall, circuit: retriever.CircuitOpen})
+	code, resp := doHealthRequest(t, h, "/readyz")
+
+	assert.Equal(t, http.StatusOK, code, "Test readiness with the circuit breaker open")
+	assert.Equal(t, statusFailed, resp.Checks["ccx"].Status, "Test ccx check")
+	assert.Contains(t, resp.Checks["ccx"].Message, "circuit breaker open", "Test ccx check message")
+	assert.Equal(t, lastCall, *resp.Checks["ccx"].LastTime, "Test ccx last successful call")
+
+	h = NewHealthHandler(&fakeMonitor{hubID: "hub", informerRunning: true},
+		&fakeRetriever{lastCall: lastCall, circuit: retriever.CircuitHalfOpen})
+	_, resp = doHealthRequest(t, h, "/readyz")
+	assert.Equal(t, statusPending, resp.Checks["ccx"].Status, "Test ccx check while probing")
+}
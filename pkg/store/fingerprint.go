@@ -0,0 +1,52 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/types"
+)
+
+// ReportFingerprint identifies the content of a CCX report, reports with the same fingerprint come from the
+// same check of the same archive and report the same rules
+type ReportFingerprint struct {
+	LastCheckedAt time.Time
+	GatheredAt    time.Time
+	Rules         string // sorted rule IDs and error keys
+}
+
+// NewReportFingerprint returns the fingerprint of the report
+func NewReportFingerprint(report types.ReportBody) ReportFingerprint {
+	rules := make([]string, 0, len(report.Data))
+	for _, data := range report.Data {
+		rules = append(rules, data.RuleID+"|"+data.ExtraData.ErrorKey)
+	}
+	sort.Strings(rules)
+	return ReportFingerprint{
+		LastCheckedAt: report.Meta.LastCheckedAt,
+		GatheredAt:    report.Meta.GatheredAt,
+		Rules:         strings.Join(rules, "\n"),
+	}
+}
+
+// Equal returns true when both fingerprints identify the same report
+func (f ReportFingerprint) Equal(other ReportFingerprint) bool {
+	return f.LastCheckedAt.Equal(other.LastCheckedAt) && f.GatheredAt.Equal(other.GatheredAt) && f.Rules == other.Rules
+}
+
+// SetProcessed records the fingerprint of the report whose results were written to the PolicyReport of the cluster
+func (s *ReportStore) SetProcessed(cluster types.ManagedClusterInfo, fingerprint ReportFingerprint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entry(cluster).Processed = &fingerprint
+}
+
+// Validators are the ETag and Last-Modified headers of the response a report was received in,
+// they are sent back to CCX to only receive the report when it changed
+type Validators struct {
+	ETag         string
+	LastModified string
+}
@@ -0,0 +1,49 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewReportFingerprint(t *testing.T) {
+	report := types.ReportBody{
+		Data: []types.ReportData{
+			{RuleID: "rule1", ExtraData: types.ExtraData{ErrorKey: "KEY1"}},
+			{RuleID: "rule2", ExtraData: types.ExtraData{ErrorKey: "KEY2"}},
+		},
+		Meta: types.MetaData{LastCheckedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	reordered := types.ReportBody{
+		Data: []types.ReportData{report.Data[1], report.Data[0]},
+		Meta: types.MetaData{LastCheckedAt: report.Meta.LastCheckedAt.In(time.FixedZone("CET", 3600))},
+	}
+	assert.True(t, NewReportFingerprint(report).Equal(NewReportFingerprint(reordered)),
+		"Expected the fingerprint to ignore the order of the rules and the time zone")
+
+	otherKey := types.ReportBody{Data: []types.ReportData{report.Data[0], {RuleID: "rule2", ExtraData: types.ExtraData{ErrorKey: "KEY3"}}},
+		Meta: report.Meta}
+	assert.False(t, NewReportFingerprint(report).Equal(NewReportFingerprint(otherKey)),
+		"Expected the fingerprint to include the error keys")
+}
+
+func Test_SetReport_Validators(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{}, Validators{ETag: `"v1"`, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"})
+
+	entry, _ := s.Get("managed-cluster")
+	assert.Equal(t, `"v1"`, entry.ETag, "Test SetReport ETag")
+	assert.Equal(t, "Mon, 01 Jan 2024 00:00:00 GMT", entry.LastModified, "Test SetReport Last-Modified")
+
+	s.SetReport(testCluster, types.ReportBody{}, Validators{})
+	entry, _ = s.Get("managed-cluster")
+	assert.Equal(t, "", entry.ETag, "Test SetReport replaces the validators of the previous report")
+
+	s.DropReport("managed-cluster")
+	entry, _ = s.Get("managed-cluster")
+	assert.Equal(t, "", entry.ETag, "Test DropReport drops the validators")
+}
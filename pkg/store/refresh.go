@@ -0,0 +1,169 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Refresh status values
+const (
+	RefreshPending   = "pending"
+	RefreshCompleted = "completed"
+	RefreshFailed    = "failed" // at least one cluster was skipped
+)
+
+// How long a completed refresh can be polled before it is forgotten
+const refreshRetention = time.Hour
+
+// How long a refresh waits for its clusters, the clusters still pending are then skipped
+const refreshTimeout = time.Hour
+
+// Reasons of the clusters skipped by a refresh
+const (
+	SkipNotSelected = "not selected by the InsightsClientConfig"
+	SkipNotManaged  = "cluster is no longer managed"
+	SkipTimeout     = "not processed within one hour"
+)
+
+// Refresh tracks an on-demand refresh of one or more clusters until their PolicyReport is written
+type Refresh struct {
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Clusters    []string          `json:"clusters"`
+	Pending     []string          `json:"pending"`
+	Skipped     map[string]string `json:"skipped,omitempty"` // reason of each cluster not refreshed
+	RequestedAt time.Time         `json:"requestedAt"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty"`
+}
+
+// StartRefresh creates a refresh for the given cluster namespaces and returns it
+func (s *ReportStore) StartRefresh(namespaces []string) Refresh {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pruneRefreshes()
+	now := time.Now()
+	refresh := &Refresh{
+		ID:          uuid.NewString(),
+		Status:      RefreshPending,
+		Clusters:    append([]string{}, namespaces...),
+		Pending:     append([]string{}, namespaces...),
+		RequestedAt: now,
+	}
+	sort.Strings(refresh.Clusters)
+	sort.Strings(refresh.Pending)
+	if len(refresh.Pending) == 0 {
+		refresh.Status = RefreshCompleted
+		refresh.CompletedAt = &now
+	}
+	s.refreshes[refresh.ID] = refresh
+	return copyRefresh(refresh)
+}
+
+// GetRefresh returns the refresh with the given ID
+func (s *ReportStore) GetRefresh(id string) (Refresh, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pruneRefreshes()
+	refresh, ok := s.refreshes[id]
+	if !ok {
+		return Refresh{}, false
+	}
+	return copyRefresh(refresh), true
+}
+
+// PendingRefreshes returns the number of refreshes waiting for their clusters
+func (s *ReportStore) PendingRefreshes() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pruneRefreshes()
+	count := 0
+	for _, refresh := range s.refreshes {
+		if refresh.Status == RefreshPending {
+			count++
+		}
+	}
+	return count
+}
+
+// CompleteRefresh is called once the PolicyReport of the cluster is processed. Every refresh of the
+// cluster requested before retrievedAt, the time the retriever picked up the cluster, is complete for it.
+func (s *ReportStore) CompleteRefresh(namespace string, retrievedAt time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.finishRefreshes(namespace, retrievedAt, "")
+}
+
+// SkipRefresh is called when the cluster is not refreshed, e.g. the CCX circuit breaker is open. Every refresh
+// of the cluster requested before skippedAt records the reason and stops waiting for it.
+func (s *ReportStore) SkipRefresh(namespace string, skippedAt time.Time, reason string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.finishRefreshes(namespace, skippedAt, reason)
+}
+
+// finishRefreshes removes the cluster from the pending refreshes requested before the given time, skipped with
+// the reason when it is not empty. Must be called with lock held.
+func (s *ReportStore) finishRefreshes(namespace string, before time.Time, reason string) {
+	for _, refresh := range s.refreshes {
+		if refresh.Status != RefreshPending || before.Before(refresh.RequestedAt) {
+			continue
+		}
+		finishRefresh(refresh, namespace, reason)
+	}
+}
+
+// finishRefresh must be called with lock held
+func finishRefresh(refresh *Refresh, namespace string, reason string) {
+	for i, pending := range refresh.Pending {
+		if pending == namespace {
+			refresh.Pending = append(refresh.Pending[:i], refresh.Pending[i+1:]...)
+			if reason != "" {
+				if refresh.Skipped == nil {
+					refresh.Skipped = map[string]string{}
+				}
+				refresh.Skipped[namespace] = reason
+			}
+			break
+		}
+	}
+	if len(refresh.Pending) == 0 {
+		now := time.Now()
+		refresh.Status = RefreshCompleted
+		if len(refresh.Skipped) > 0 {
+			refresh.Status = RefreshFailed
+		}
+		refresh.CompletedAt = &now
+	}
+}
+
+// pruneRefreshes skips the clusters of the refreshes pending for more than refreshTimeout, and forgets the
+// refreshes completed for more than refreshRetention. Must be called with lock held.
+func (s *ReportStore) pruneRefreshes() {
+	for id, refresh := range s.refreshes {
+		if refresh.Status == RefreshPending && time.Since(refresh.RequestedAt) > refreshTimeout {
+			for _, namespace := range append([]string{}, refresh.Pending...) {
+				finishRefresh(refresh, namespace, SkipTimeout)
+			}
+		}
+		if refresh.CompletedAt != nil && time.Since(*refresh.CompletedAt) > refreshRetention {
+			delete(s.refreshes, id)
+		}
+	}
+}
+
+func copyRefresh(refresh *Refresh) Refresh {
+	c := *refresh
+	c.Clusters = append([]string{}, refresh.Clusters...)
+	c.Pending = append([]string{}, refresh.Pending...)
+	if refresh.Skipped != nil {
+		c.Skipped = map[string]string{}
+		for namespace, reason := range refresh.Skipped {
+			c.Skipped[namespace] = reason
+		}
+	}
+	return c
+}
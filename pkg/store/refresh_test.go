@@ -0,0 +1,94 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Refresh(t *testing.T) {
+	s := NewReportStore()
+	refresh := s.StartRefresh([]string{"managed-cluster", "local-cluster"})
+	assert.Equal(t, RefreshPending, refresh.Status, "Test new refresh is pending")
+	assert.Equal(t, []string{"local-cluster", "managed-cluster"}, refresh.Pending, "Test new refresh pending clusters")
+
+	s.CompleteRefresh("local-cluster", time.Now())
+	refresh, found := s.GetRefresh(refresh.ID)
+	assert.True(t, found, "Test GetRefresh")
+	assert.Equal(t, RefreshPending, refresh.Status, "Test refresh is pending until every cluster is processed")
+	assert.Equal(t, []string{"managed-cluster"}, refresh.Pending, "Test processed cluster is not pending")
+
+	s.CompleteRefresh("managed-cluster", time.Now())
+	refresh, _ = s.GetRefresh(refresh.ID)
+	assert.Equal(t, RefreshCompleted, refresh.Status, "Test refresh is completed")
+	assert.NotNil(t, refresh.CompletedAt, "Test refresh completion time")
+}
+
+// A report retrieved before the refresh was requested does not complete it
+func Test_Refresh_RetrievedBefore(t *testing.T) {
+	s := NewReportStore()
+	retrievedAt := time.Now().Add(-time.Second)
+	refresh := s.StartRefresh([]string{"managed-cluster"})
+
+	s.CompleteRefresh("managed-cluster", retrievedAt)
+	refresh, _ = s.GetRefresh(refresh.ID)
+	assert.Equal(t, RefreshPending, refresh.Status, "Test refresh is still pending")
+}
+
+func Test_Refresh_NotFound(t *testing.T) {
+	s := NewReportStore()
+	_, found := s.GetRefresh("unknown")
+	assert.False(t, found, "Test GetRefresh of unknown ID")
+}
+
+// Completed refreshes are forgotten after the retention, pending ones fail after the timeout
+func Test_Refresh_Prune(t *testing.T) {
+	s := NewReportStore()
+	pending := s.StartRefresh([]string{"managed-cluster"})
+	completed := s.StartRefresh([]string{"local-cluster"})
+	s.CompleteRefresh("local-cluster", time.Now())
+	old := time.Now().Add(-2 * refreshRetention)
+	s.refreshes[pending.ID].RequestedAt = old
+	s.refreshes[completed.ID].RequestedAt = old
+	s.refreshes[completed.ID].CompletedAt = &old
+
+	s.StartRefresh([]string{"managed-cluster"})
+	refresh, found := s.GetRefresh(pending.ID)
+	assert.True(t, found, "Test timed out refresh is kept")
+	assert.Equal(t, RefreshFailed, refresh.Status, "Test timed out refresh failed")
+	assert.Equal(t, map[string]string{"managed-cluster": SkipTimeout}, refresh.Skipped, "Test timed out cluster")
+	assert.Equal(t, 1, s.PendingRefreshes(), "Test timed out refresh is not pending")
+	_, found = s.GetRefresh(completed.ID)
+	assert.False(t, found, "Test old completed refresh is forgotten")
+}
+
+func Test_Refresh_Skip(t *testing.T) {
+	s := NewReportStore()
+	refresh := s.StartRefresh([]string{"managed-cluster", "local-cluster"})
+
+	s.SkipRefresh("managed-cluster", time.Now(), SkipNotSelected)
+	refresh, _ = s.GetRefresh(refresh.ID)
+	assert.Equal(t, RefreshPending, refresh.Status, "Test refresh is pending until every cluster is done")
+	assert.Equal(t, []string{"local-cluster"}, refresh.Pending, "Test skipped cluster is not pending")
+
+	s.CompleteRefresh("local-cluster", time.Now())
+	refresh, _ = s.GetRefresh(refresh.ID)
+	assert.Equal(t, RefreshFailed, refresh.Status, "Test refresh with a skipped cluster failed")
+	assert.Equal(t, map[string]string{"managed-cluster": SkipNotSelected}, refresh.Skipped, "Test skip reason")
+	assert.NotNil(t, refresh.CompletedAt, "Test refresh completion time")
+}
+
+// The refreshes stop waiting for the clusters that are no longer managed
+func Test_Refresh_Retain(t *testing.T) {
+	s := NewReportStore()
+	refresh := s.StartRefresh([]string{"managed-cluster"})
+
+	s.Retain([]types.ManagedClusterInfo{{Namespace: "local-cluster", ClusterID: "local-id"}})
+	refresh, _ = s.GetRefresh(refresh.ID)
+	assert.Equal(t, RefreshFailed, refresh.Status, "Test refresh of removed cluster failed")
+	assert.Equal(t, map[string]string{"managed-cluster": SkipNotManaged}, refresh.Skipped, "Test removed cluster")
+}
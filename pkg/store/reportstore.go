@@ -0,0 +1,139 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+// ClusterReport holds the state of the last report fetch for a cluster
+type ClusterReport struct {
+	ClusterInfo  types.ManagedClusterInfo
+	LastFetch    time.Time                    // time of the last fetch attempt
+	LastError    string                       // error of the last fetch attempt, empty if it succeeded
+	Report       *types.ReportBody            // last report successfully received, nil if none
+	ReportTime   time.Time                    // time the report was received
+	ETag         string                       // ETag of the response with the report, sent as If-None-Match
+	LastModified string                       // Last-Modified of the response with the report, sent as If-Modified-Since
+	Results      []v1beta1.PolicyReportResult // results of the last PolicyReport written by the processor
+	Processed    *ReportFingerprint           // report whose results are in the PolicyReport, nil if none since the start
+}
+
+// ReportStore keeps the latest report received for each cluster, keyed by cluster namespace
+type ReportStore struct {
+	lock      sync.RWMutex
+	reports   map[string]*ClusterReport
+	refreshes map[string]*Refresh                      // on-demand refreshes keyed by ID
+	rules     map[string]map[string][]types.ReportData // reported rules keyed by rule ID and cluster namespace, one per error key
+}
+
+// AllowAll is a cluster filter allowing every cluster
+func AllowAll(namespace string) bool {
+	return true
+}
+
+// NewReportStore ...
+func NewReportStore() *ReportStore {
+	return &ReportStore{
+		reports:   map[string]*ClusterReport{},
+		refreshes: map[string]*Refresh{},
+		rules:     map[string]map[string][]types.ReportData{},
+	}
+}
+
+func (s *ReportStore) entry(cluster types.ManagedClusterInfo) *ClusterReport {
+	entry, ok := s.reports[cluster.Namespace]
+	if !ok || entry.ClusterInfo.ClusterID != cluster.ClusterID {
+		// New cluster, or the cluster was re-imported with a different ID
+		s.unindexReport(cluster.Namespace)
+		entry = &ClusterReport{}
+		s.reports[cluster.Namespace] = entry
+	}
+	entry.ClusterInfo = cluster
+	return entry
+}
+
+// SetReport records a successful fetch of the cluster report with the validators of its response
+func (s *ReportStore) SetReport(cluster types.ManagedClusterInfo, report types.ReportBody, validators Validators) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	entry := s.entry(cluster)
+	entry.LastFetch = now
+	entry.LastError = ""
+	entry.Report = &report
+	entry.ReportTime = now
+	entry.ETag = validators.ETag
+	entry.LastModified = validators.LastModified
+	s.indexReport(cluster, report)
+}
+
+// SetError records a failed fetch of the cluster report, the last report received is kept
+func (s *ReportStore) SetError(cluster types.ManagedClusterInfo, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry := s.entry(cluster)
+	entry.LastFetch = time.Now()
+	entry.LastError = err.Error()
+}
+
+// DropReport forgets the last report received for the cluster, e.g. once it expired
+func (s *ReportStore) DropReport(namespace string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.reports[namespace]
+	if !ok {
+		return
+	}
+	entry.Report = nil
+	entry.ReportTime = time.Time{}
+	entry.ETag = ""
+	entry.LastModified = ""
+	s.unindexReport(namespace)
+}
+
+// Get returns a copy of the state of the cluster with the given namespace
+func (s *ReportStore) Get(namespace string) (ClusterReport, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	entry, ok := s.reports[namespace]
+	if !ok {
+		return ClusterReport{}, false
+	}
+	return *entry, true
+}
+
+// Delete removes the cluster with the given namespace
+func (s *ReportStore) Delete(namespace string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.reports, namespace)
+	s.unindexReport(namespace)
+}
+
+// Retain removes every cluster that is not in the given list, the pending refreshes stop waiting for them
+func (s *ReportStore) Retain(clusters []types.ManagedClusterInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keep := map[string]bool{}
+	for _, cluster := range clusters {
+		keep[cluster.Namespace] = true
+	}
+	for namespace := range s.reports {
+		if !keep[namespace] {
+			delete(s.reports, namespace)
+			s.unindexReport(namespace)
+		}
+	}
+	for _, refresh := range s.refreshes {
+		for _, namespace := range append([]string{}, refresh.Pending...) {
+			if !keep[namespace] && refresh.Status == RefreshPending {
+				finishRefresh(refresh, namespace, SkipNotManaged)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCluster = types.ManagedClusterInfo{Namespace: "managed-cluster", ClusterID: "323a00cd-428a-49fb-80ab-201d2a5d3050"}
+
+func Test_SetReport(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{{RuleID: "rule1"}}}, Validators{})
+
+	entry, found := s.Get("managed-cluster")
+	assert.True(t, found, "Test Get after SetReport")
+	assert.Equal(t, testCluster, entry.ClusterInfo, "Test SetReport cluster info")
+	assert.Equal(t, "rule1", entry.Report.Data[0].RuleID, "Test SetReport report")
+	assert.Equal(t, "", entry.LastError, "Test SetReport clears error")
+	assert.False(t, entry.LastFetch.IsZero(), "Test SetReport fetch time")
+}
+
+func Test_SetError_KeepsReport(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{{RuleID: "rule1"}}}, Validators{})
+	s.SetError(testCluster, errors.New("no Success HTTP Response code "))
+
+	entry, _ := s.Get("managed-cluster")
+	assert.Equal(t, "no Success HTTP Response code ", entry.LastError, "Test SetError error")
+	assert.Equal(t, "rule1", entry.Report.Data[0].RuleID, "Test SetError keeps last report")
+}
+
+func Test_SetReport_NewClusterID(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{{RuleID: "rule1"}}}, Validators{})
+	s.SetError(types.ManagedClusterInfo{Namespace: "managed-cluster", ClusterID: "new-id"}, errors.New("failed"))
+
+	entry, _ := s.Get("managed-cluster")
+	assert.Nil(t, entry.Report, "Test report of a re-imported cluster is dropped")
+}
+
+func Test_Delete(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{}, Validators{})
+	s.Delete("managed-cluster")
+
+	_, found := s.Get("managed-cluster")
+	assert.False(t, found, "Test Get after Delete")
+}
+
+func Test_Retain(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{}, Validators{})
+	s.SetReport(types.ManagedClusterInfo{Namespace: "deleted-cluster", ClusterID: "deleted-id"}, types.ReportBody{},
+		Validators{})
+	s.Retain([]types.ManagedClusterInfo{testCluster})
+
+	_, found := s.Get("managed-cluster")
+	assert.True(t, found, "Test Retain keeps managed cluster")
+	_, found = s.Get("deleted-cluster")
+	assert.False(t, found, "Test Retain removes deleted cluster")
+}
+
+func Test_DropReport(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{{RuleID: "rule1"}}}, Validators{})
+	s.SetError(testCluster, errors.New("failed"))
+	s.DropReport("managed-cluster")
+
+	entry, found := s.Get("managed-cluster")
+	assert.True(t, found, "Test DropReport keeps the cluster")
+	assert.Nil(t, entry.Report, "Test DropReport drops the report")
+	assert.Equal(t, "failed", entry.LastError, "Test DropReport keeps the error")
+	_, found = s.GetRuleClusters("rule1", AllowAll)
+	assert.False(t, found, "Test DropReport unindexes the rules")
+}
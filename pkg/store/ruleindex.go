@@ -0,0 +1,133 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"sort"
+
+	"github.com/stolostron/insights-client/pkg/types"
+)
+
+// RuleSummary is the fleet-wide impact of an Insights rule
+type RuleSummary struct {
+	RuleID           string `json:"ruleID"`
+	Description      string `json:"description"`
+	ImpactedClusters int    `json:"impactedClusters"`
+	HighestTotalRisk int    `json:"highestTotalRisk"`
+}
+
+// RuleImpact is the impact of an Insights rule on a single cluster, a cluster has one for each error key reported
+type RuleImpact struct {
+	Cluster   string   `json:"cluster"`
+	ClusterID string   `json:"clusterID"`
+	TotalRisk int      `json:"totalRisk"`
+	ErrorKey  string   `json:"errorKey"`
+	Nodes     []string `json:"nodes,omitempty"`
+}
+
+// RuleClusters is the list of clusters impacted by an Insights rule
+type RuleClusters struct {
+	RuleSummary
+	Clusters []RuleImpact `json:"clusters"`
+}
+
+// indexReport replaces the rules indexed for the cluster, must be called with lock held
+func (s *ReportStore) indexReport(cluster types.ManagedClusterInfo, report types.ReportBody) {
+	s.unindexReport(cluster.Namespace)
+	for _, data := range report.Data {
+		clusters, ok := s.rules[data.RuleID]
+		if !ok {
+			clusters = map[string][]types.ReportData{}
+			s.rules[data.RuleID] = clusters
+		}
+		// A plugin can report the same rule with several error keys
+		clusters[cluster.Namespace] = append(clusters[cluster.Namespace], data)
+	}
+}
+
+// unindexReport removes the rules indexed for the cluster, must be called with lock held
+func (s *ReportStore) unindexReport(namespace string) {
+	for ruleID, clusters := range s.rules {
+		delete(clusters, namespace)
+		if len(clusters) == 0 {
+			delete(s.rules, ruleID)
+		}
+	}
+}
+
+// ListRules returns every rule impacting at least one of the allowed clusters, the riskiest first
+func (s *ReportStore) ListRules(allowed func(namespace string) bool) []RuleSummary {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	rules := make([]RuleSummary, 0, len(s.rules))
+	for ruleID := range s.rules {
+		if summary := s.ruleSummary(ruleID, allowed); summary.ImpactedClusters > 0 {
+			rules = append(rules, summary)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].HighestTotalRisk != rules[j].HighestTotalRisk {
+			return rules[i].HighestTotalRisk > rules[j].HighestTotalRisk
+		}
+		if rules[i].ImpactedClusters != rules[j].ImpactedClusters {
+			return rules[i].ImpactedClusters > rules[j].ImpactedClusters
+		}
+		return rules[i].RuleID < rules[j].RuleID
+	})
+	return rules
+}
+
+// GetRuleClusters returns the allowed clusters impacted by the rule, false if none is impacted
+func (s *ReportStore) GetRuleClusters(ruleID string, allowed func(namespace string) bool) (RuleClusters, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	summary := s.ruleSummary(ruleID, allowed)
+	if summary.ImpactedClusters == 0 {
+		return RuleClusters{}, false
+	}
+	result := RuleClusters{
+		RuleSummary: summary,
+		Clusters:    make([]RuleImpact, 0, summary.ImpactedClusters),
+	}
+	for namespace, reported := range s.rules[ruleID] {
+		if !allowed(namespace) {
+			continue
+		}
+		for _, data := range reported {
+			result.Clusters = append(result.Clusters, RuleImpact{
+				Cluster:   namespace,
+				ClusterID: s.reports[namespace].ClusterInfo.ClusterID,
+				TotalRisk: data.TotalRisk,
+				ErrorKey:  data.ExtraData.ErrorKey,
+				Nodes:     data.ExtraData.Nodes,
+			})
+		}
+	}
+	sort.Slice(result.Clusters, func(i, j int) bool {
+		if result.Clusters[i].Cluster != result.Clusters[j].Cluster {
+			return result.Clusters[i].Cluster < result.Clusters[j].Cluster
+		}
+		return result.Clusters[i].ErrorKey < result.Clusters[j].ErrorKey
+	})
+	return result, true
+}
+
+// ruleSummary must be called with lock held
+func (s *ReportStore) ruleSummary(ruleID string, allowed func(namespace string) bool) RuleSummary {
+	summary := RuleSummary{RuleID: ruleID}
+	for namespace, reported := range s.rules[ruleID] {
+		if !allowed(namespace) {
+			continue
+		}
+		summary.ImpactedClusters++
+		for _, data := range reported {
+			if data.TotalRisk > summary.HighestTotalRisk {
+				summary.HighestTotalRisk = data.TotalRisk
+			}
+			if summary.Description == "" {
+				summary.Description = data.Description
+			}
+		}
+	}
+	return summary
+}
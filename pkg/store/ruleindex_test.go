@@ -0,0 +1,113 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"testing"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	machinesetsRule = types.ReportData{
+		RuleID:      "master_defined_as_machinesets|MASTER_DEFINED_AS_MACHINESETS",
+		Description: "Master nodes are defined as machinesets",
+		TotalRisk:   3,
+		ExtraData:   types.ExtraData{ErrorKey: "MASTER_DEFINED_AS_MACHINESETS"},
+	}
+	partitionRule = types.ReportData{
+		RuleID:      "container_max_root_partition_size|CONTAINER_ROOT_PARTITION_SIZE",
+		Description: "Container max root partition size issue",
+		TotalRisk:   2,
+		ExtraData:   types.ExtraData{ErrorKey: "CONTAINER_ROOT_PARTITION_SIZE", Nodes: []string{"worker-0"}},
+	}
+	otherCluster = types.ManagedClusterInfo{Namespace: "other-cluster", ClusterID: "972ea7cf-7428-438f-ade8-12ac4794ede0"}
+)
+
+func Test_ListRules(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{machinesetsRule, partitionRule}}, Validators{})
+	s.SetReport(otherCluster, types.ReportBody{Data: []types.ReportData{partitionRule}}, Validators{})
+
+	rules := s.ListRules(AllowAll)
+	assert.Equal(t, 2, len(rules), "Test ListRules length")
+	assert.Equal(t, RuleSummary{
+		RuleID:           machinesetsRule.RuleID,
+		Description:      machinesetsRule.Description,
+		ImpactedClusters: 1,
+		HighestTotalRisk: 3,
+	}, rules[0], "Test ListRules riskiest rule first")
+	assert.Equal(t, 2, rules[1].ImpactedClusters, "Test ListRules impacted clusters")
+}
+
+func Test_GetRuleClusters(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{machinesetsRule, partitionRule}}, Validators{})
+	s.SetReport(otherCluster, types.ReportBody{Data: []types.ReportData{partitionRule}}, Validators{})
+
+	result, found := s.GetRuleClusters(partitionRule.RuleID, AllowAll)
+	assert.True(t, found, "Test GetRuleClusters found")
+	assert.Equal(t, 2, result.ImpactedClusters, "Test GetRuleClusters impacted clusters")
+	assert.Equal(t, RuleImpact{
+		Cluster:   "managed-cluster",
+		ClusterID: testCluster.ClusterID,
+		TotalRisk: 2,
+		ErrorKey:  "CONTAINER_ROOT_PARTITION_SIZE",
+		Nodes:     []string{"worker-0"},
+	}, result.Clusters[0], "Test GetRuleClusters impact")
+}
+
+// A rule reported with several error keys on a cluster keeps every error key and its nodes
+func Test_GetRuleClusters_ErrorKeys(t *testing.T) {
+	s := NewReportStore()
+	nodesKey := types.ReportData{
+		RuleID:    "ccx_rules_ocp.external.rules.nodes_requirements_check.report",
+		TotalRisk: 2,
+		ExtraData: types.ExtraData{ErrorKey: "NODES_MINIMUM_REQUIREMENTS_NOT_MET", Nodes: []string{"worker-0"}},
+	}
+	kubeletKey := types.ReportData{
+		RuleID:    "ccx_rules_ocp.external.rules.nodes_requirements_check.report",
+		TotalRisk: 3,
+		ExtraData: types.ExtraData{ErrorKey: "NODE_KUBELET_VERSION", Nodes: []string{"master-0"}},
+	}
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{nodesKey, kubeletKey}}, Validators{})
+
+	result, found := s.GetRuleClusters(nodesKey.RuleID, AllowAll)
+	assert.True(t, found, "Test GetRuleClusters found")
+	assert.Equal(t, 1, result.ImpactedClusters, "Test the cluster is counted once")
+	assert.Equal(t, 3, result.HighestTotalRisk, "Test highest total risk of the error keys")
+	assert.Equal(t, []RuleImpact{
+		{Cluster: "managed-cluster", ClusterID: testCluster.ClusterID, TotalRisk: 2,
+			ErrorKey: "NODES_MINIMUM_REQUIREMENTS_NOT_MET", Nodes: []string{"worker-0"}},
+		{Cluster: "managed-cluster", ClusterID: testCluster.ClusterID, TotalRisk: 3,
+			ErrorKey: "NODE_KUBELET_VERSION", Nodes: []string{"master-0"}},
+	}, result.Clusters, "Test every error key is returned")
+}
+
+// A new report replaces the rules of the cluster
+func Test_RuleIndex_Update(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{machinesetsRule}}, Validators{})
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{partitionRule}}, Validators{})
+
+	_, found := s.GetRuleClusters(machinesetsRule.RuleID, AllowAll)
+	assert.False(t, found, "Test resolved rule is removed")
+
+	s.Delete(testCluster.Namespace)
+	assert.Equal(t, 0, len(s.ListRules(AllowAll)), "Test rules of deleted cluster are removed")
+}
+
+func Test_RuleIndex_Allowed(t *testing.T) {
+	s := NewReportStore()
+	s.SetReport(testCluster, types.ReportBody{Data: []types.ReportData{machinesetsRule, partitionRule}}, Validators{})
+	s.SetReport(otherCluster, types.ReportBody{Data: []types.ReportData{partitionRule}}, Validators{})
+	onlyOther := func(namespace string) bool { return namespace == otherCluster.Namespace }
+
+	rules := s.ListRules(onlyOther)
+	assert.Equal(t, 1, len(rules), "Test ListRules only returns rules of allowed clusters")
+	assert.Equal(t, 1, rules[0].ImpactedClusters, "Test ListRules only counts allowed clusters")
+
+	_, found := s.GetRuleClusters(machinesetsRule.RuleID, onlyOther)
+	assert.False(t, found, "Test GetRuleClusters of a rule impacting no allowed cluster")
+}
@@ -0,0 +1,88 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+// ClusterRisk is the risk of a single cluster, RiskScore is the sum of the total_risk of its results
+type ClusterRisk struct {
+	Cluster          string `json:"cluster"`
+	ClusterID        string `json:"clusterID"`
+	Violations       int    `json:"violations"`
+	HighestTotalRisk int    `json:"highestTotalRisk"`
+	RiskScore        int    `json:"riskScore"`
+}
+
+// FleetSummary aggregates the current PolicyReport results of every cluster
+type FleetSummary struct {
+	Clusters         int            `json:"clusters"`
+	Violations       int            `json:"violations"`
+	ByTotalRisk      map[string]int `json:"byTotalRisk"`
+	ByCategory       map[string]int `json:"byCategory"`
+	BySource         map[string]int `json:"bySource"`
+	RiskiestClusters []ClusterRisk  `json:"riskiestClusters"`
+}
+
+// SetResults records the PolicyReport results written by the processor for the cluster
+func (s *ReportStore) SetResults(cluster types.ManagedClusterInfo, results []v1beta1.PolicyReportResult) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entry(cluster).Results = results
+}
+
+// Summary aggregates the results of the allowed clusters and returns the top riskiest clusters
+func (s *ReportStore) Summary(top int, allowed func(namespace string) bool) FleetSummary {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	summary := FleetSummary{
+		ByTotalRisk:      map[string]int{},
+		ByCategory:       map[string]int{},
+		BySource:         map[string]int{},
+		RiskiestClusters: []ClusterRisk{},
+	}
+	var risks []ClusterRisk
+	for namespace, entry := range s.reports {
+		if !allowed(namespace) {
+			continue
+		}
+		summary.Clusters++
+		if len(entry.Results) == 0 {
+			continue
+		}
+		risk := ClusterRisk{Cluster: namespace, ClusterID: entry.ClusterInfo.ClusterID}
+		for _, result := range entry.Results {
+			summary.Violations++
+			summary.ByTotalRisk[result.Properties["total_risk"]]++
+			summary.ByCategory[result.Category]++
+			summary.BySource[result.Source]++
+
+			totalRisk, _ := strconv.Atoi(result.Properties["total_risk"])
+			risk.Violations++
+			risk.RiskScore += totalRisk
+			if totalRisk > risk.HighestTotalRisk {
+				risk.HighestTotalRisk = totalRisk
+			}
+		}
+		risks = append(risks, risk)
+	}
+	sort.Slice(risks, func(i, j int) bool {
+		if risks[i].RiskScore != risks[j].RiskScore {
+			return risks[i].RiskScore > risks[j].RiskScore
+		}
+		if risks[i].HighestTotalRisk != risks[j].HighestTotalRisk {
+			return risks[i].HighestTotalRisk > risks[j].HighestTotalRisk
+		}
+		return risks[i].Cluster < risks[j].Cluster
+	})
+	if len(risks) > top {
+		risks = risks[:top]
+	}
+	summary.RiskiestClusters = append(summary.RiskiestClusters, risks...)
+	return summary
+}
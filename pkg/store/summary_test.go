@@ -0,0 +1,61 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package store
+
+import (
+	"testing"
+
+	"github.com/stolostron/insights-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/wg-policy-prototypes/policy-report/pkg/api/wgpolicyk8s.io/v1beta1"
+)
+
+func result(source string, category string, totalRisk string) v1beta1.PolicyReportResult {
+	return v1beta1.PolicyReportResult{
+		Source:     source,
+		Category:   category,
+		Properties: map[string]string{"total_risk": totalRisk},
+	}
+}
+
+func Test_Summary(t *testing.T) {
+	s := NewReportStore()
+	s.SetResults(testCluster, []v1beta1.PolicyReportResult{
+		result("insights", "incident", "2"),
+		result("insights", "incident,security", "2"),
+	})
+	s.SetResults(otherCluster, []v1beta1.PolicyReportResult{
+		result("grc", "CM Configuration Management", "4"),
+	})
+	s.SetResults(types.ManagedClusterInfo{Namespace: "healthy-cluster", ClusterID: "healthy-id"}, nil)
+
+	summary := s.Summary(10, AllowAll)
+	assert.Equal(t, 3, summary.Clusters, "Test summary clusters")
+	assert.Equal(t, 3, summary.Violations, "Test summary violations")
+	assert.Equal(t, map[string]int{"incident": 1, "incident,security": 1, "CM Configuration Management": 1},
+		summary.ByCategory, "Test summary by category")
+	assert.Equal(t, 2, len(summary.RiskiestClusters), "Test healthy clusters are not ranked")
+	// Same risk score, highest total risk first
+	assert.Equal(t, "other-cluster", summary.RiskiestClusters[0].Cluster, "Test riskiest cluster")
+	assert.Equal(t, 4, summary.RiskiestClusters[1].RiskScore, "Test risk score")
+}
+
+func Test_Summary_Top(t *testing.T) {
+	s := NewReportStore()
+	s.SetResults(testCluster, []v1beta1.PolicyReportResult{result("insights", "incident", "1")})
+	s.SetResults(otherCluster, []v1beta1.PolicyReportResult{result("insights", "incident", "3")})
+
+	summary := s.Summary(1, AllowAll)
+	assert.Equal(t, 1, len(summary.RiskiestClusters), "Test summary top")
+	assert.Equal(t, "other-cluster", summary.RiskiestClusters[0].Cluster, "Test summary top cluster")
+}
+
+func Test_Summary_Allowed(t *testing.T) {
+	s := NewReportStore()
+	s.SetResults(testCluster, []v1beta1.PolicyReportResult{result("insights", "incident", "1")})
+	s.SetResults(otherCluster, []v1beta1.PolicyReportResult{result("insights", "incident", "3")})
+
+	summary := s.Summary(10, func(namespace string) bool { return namespace == testCluster.Namespace })
+	assert.Equal(t, 1, summary.Clusters, "Test summary only counts allowed clusters")
+	assert.Equal(t, "managed-cluster", summary.RiskiestClusters[0].Cluster, "Test summary allowed cluster")
+}
@@ -60,4 +60,8 @@ type Report struct {
 type ProcessorData struct {
 	ClusterInfo ManagedClusterInfo
 	Report      ReportBody
+	RetrievedAt time.Time // time the retriever picked up the cluster
+	ReportTime  time.Time // time Report was received from CCX, zero when unknown
+	Stale       bool      // the fetch failed, Report is the last one received
+	Deselected  bool      // the cluster is no longer selected by the InsightsClientConfig, its results are removed
 }
\ No newline at end of file